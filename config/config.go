@@ -1,6 +1,82 @@
 package config
 
+import (
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/port"
+	"math/big"
+	"time"
+)
+
+// RoutingPolicy specify how switch routes a verified message from in port to out ports.
+type RoutingPolicy int
+
+const (
+	// BroadcastRouting route message to all out ports.
+	BroadcastRouting RoutingPolicy = iota
+	// SplitHorizonRouting route message to all out ports except the one with the same id as the in port,
+	// so that a message is never sent back to the peer it came from. The built-in local and remote in
+	// ports are not paired with any out port, their messages are routed to all out ports.
+	SplitHorizonRouting
+	// TableRouting route message to the out ports listed in SwitchConfig.RoutingTable for the in port.
+	TableRouting
+)
+
 type SwitchConfig struct {
-	VerifySignature bool
-	ChainID         uint64
+	VerifySignature       bool
+	ChainID               uint64
+	StatefulTxCheck       bool          // check tx's nonce, balance and intrinsic gas against the latest world state
+	SeenCacheSize         int           // max number of message hashes remembered for deduplication, 0 to disable
+	SeenCacheTTL          time.Duration // how long a message hash is remembered, 0 means never expire
+	RoutingPolicy         RoutingPolicy
+	RoutingTable          map[int][]int       // in port id -> out port ids, only used by TableRouting
+	OutPortQueueSize      int                 // size of the intake queue and each OutPutFunc queue of out ports, 0 means port.DefaultQueueSize
+	OutPortOverflowPolicy port.OverflowPolicy // what out port does when its queue is full
+	PreFilters            []filter.Stage      // custom filters run before the built-in filter of the switch type
+	PostFilters           []filter.Stage      // custom filters run after the built-in filter of the switch type
+	SignerForks           []filter.SignerFork // fork schedule of tx signer scheme, empty means EIP155 from genesis, use filter.ReplayProtectedScheme to stop accepting legacy txs
+
+	// transaction admission policy, zero value means no limit
+	MaxTxSize               int      // max size of rlp encoded tx in bytes
+	MaxTxPayloadSize        int      // max size of tx payload in bytes
+	MaxTxGasLimit           uint64   // max gas limit of a tx
+	MinGasPrice             *big.Int // min gas price of a tx
+	DisableContractCreation bool     // reject contract creation tx
+
+	// who may send tx, receive tx and deploy contract, enforced by both tx and block switch, nil means no restriction
+	Permissions *filter.Permissions
+
+	// release the txs of each sender in nonce order, only used by tx switch
+	NonceOrdering     bool
+	FutureTxQueueSize int           // max number of future nonce txs held, 0 means DefaultFutureTxQueueSize
+	FutureTxTimeout   time.Duration // how long a future nonce tx is held, 0 means DefaultFutureTxTimeout
+
+	// replace the accepted tx with a tx of the same sender and nonce but higher gas price, only used by tx switch
+	ReplaceByFee bool
+	PriceBump    uint64 // min gas price bump in percent for replacement, 0 means transaction.DefaultPriceBump
+	TxIndexSize  int    // max number of (sender, nonce) slots remembered, 0 means transaction.DefaultTxIndexSize
+
+	// notify EventTxConflict when txs with the same sender and nonce are received, only used by tx switch
+	ConflictDetection bool
+
+	// check the wasm code deployed by contract creation tx, only used by tx switch
+	WasmValidation        bool
+	MaxWasmCodeSize       int      // max size of wasm code in bytes, 0 means no limit
+	DisallowedWasmImports []string // disallowed import module names, e.g. "env", or full names, e.g. "env.abort"
+
+	// chain and world state the block switch verifies blocks against, nil means the repository
+	StateProvider filter.StateProvider
+
+	// hold the blocks whose parent is unknown and verify them again once the parent is accepted,
+	// only used by block switch
+	OrphanPoolSize int           // max number of orphan blocks held, 0 to disable
+	OrphanTTL      time.Duration // how long an orphan block is held, 0 means DefaultOrphanTTL
+
+	// verify blocks on side branches against their parent state and reorganize the local chain by fork choice,
+	// only used by block switch. StateProvider must be a filter.ForkChain, otherwise the switch is not created.
+	// The default repository state provider is not a filter.ForkChain, as repository can not keep side branches,
+	// so fork handling needs a custom StateProvider on a real node. block.MemoryStateProvider is a ForkChain
+	// for simulation only, it does not execute txs.
+	ForkHandling bool
+	ForkChoice   filter.ForkChoice // nil means filter.LongestChain
+	MaxForkTips  int               // max number of branch tips tracked, 0 means block.DefaultMaxForkTips
 }
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"github.com/DSiSc/craft/log"
 	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/config"
 	common "github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/port"
 	"github.com/DSiSc/repository"
 	"sync"
 )
@@ -16,29 +18,63 @@ import (
 type BlockFilter struct {
 	eventCenter     types.EventCenter
 	verifySignature bool
+	rules           *common.ChainRules
+	permissions     *common.Permissions
+	provider        common.StateProvider
+	forkChain       common.ForkChain // nil if fork handling is disabled
+	forkChoice      common.ForkChoice
+	tips            *forkTips
 	lock            sync.Mutex
 }
 
 // create a new block filter instance.
 func NewBlockFilter(eventCenter types.EventCenter, verifySignature bool) *BlockFilter {
-	return &BlockFilter{
+	// never fails, as fork handling is disabled
+	blockFilter, _ := NewBlockFilterWithConfig(eventCenter, &config.SwitchConfig{
+		VerifySignature: verifySignature,
+	})
+	return blockFilter
+}
+
+// NewBlockFilterWithConfig create a new block filter instance by switch config.
+// An error is returned if fork handling is enabled but the state provider is not a filter.ForkChain.
+func NewBlockFilterWithConfig(eventCenter types.EventCenter, switchConfig *config.SwitchConfig) (*BlockFilter, error) {
+	blockFilter := &BlockFilter{
 		eventCenter:     eventCenter,
-		verifySignature: verifySignature,
+		verifySignature: common.VerifySignatureRequired(switchConfig.VerifySignature, switchConfig.Permissions),
+		rules:           common.NewChainRules(switchConfig.SignerForks),
+		permissions:     switchConfig.Permissions,
+		provider:        switchConfig.StateProvider,
+	}
+	if blockFilter.provider == nil {
+		blockFilter.provider = NewRepositoryStateProvider()
+	}
+	if switchConfig.ForkHandling {
+		forkChain, ok := blockFilter.provider.(common.ForkChain)
+		if !ok {
+			log.Error("State provider %T can not keep side branches, fork handling is unsupported", blockFilter.provider)
+			return nil, fmt.Errorf("state provider %T can not keep side branches, fork handling is unsupported", blockFilter.provider)
+		}
+		blockFilter.forkChain = forkChain
+		blockFilter.forkChoice = switchConfig.ForkChoice
+		if blockFilter.forkChoice == nil {
+			blockFilter.forkChoice = common.LongestChain{}
+		}
+		blockFilter.tips = newForkTips(switchConfig.MaxForkTips)
 	}
+	return blockFilter, nil
 }
 
 // Verify verify a switch message whether is validated.
 // return nil if message is validated, otherwise return relative error
 func (filter *BlockFilter) Verify(portId int, msg interface{}) error {
-	filter.lock.Lock()
-	defer filter.lock.Unlock()
 	var err error
-	switch msg := msg.(type) {
+	switch msg := port.Unwrap(msg).(type) {
 	case *types.Block:
-		err = filter.doValidate(msg)
+		err = filter.verifyBlock(msg)
 	default:
 		log.Error("Invalidate block message ")
-		err = errors.New("Invalidate block message ")
+		err = common.NewVerifyError(common.ReasonUnsupportedMessage, errors.New("Invalidate block message "))
 	}
 
 	//send verification failed event
@@ -48,52 +84,135 @@ func (filter *BlockFilter) Verify(portId int, msg interface{}) error {
 	return err
 }
 
-// do verify operation
-func (filter *BlockFilter) doValidate(block *types.Block) error {
+// verify block, the cheap checks run before the senders of block's txs are recovered,
+// so a junk block can neither cost the signature recoveries nor flush the sender cache.
+func (filter *BlockFilter) verifyBlock(block *types.Block) error {
+	if err := filter.precheck(block); err != nil {
+		return err
+	}
+	// recover tx senders concurrently before taking the lock, worker will use the cached senders.
+	if filter.verifySignature {
+		signer := filter.rules.Signer(block.Header.ChainID, block.Header.Height)
+		common.DefaultSenderCache.RecoverSenders(block.Header.ChainID, signer, block.Transactions)
+	}
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+	return filter.doValidate(block)
+}
+
+// check block header hash and whether previous block is known
+func (filter *BlockFilter) precheck(block *types.Block) error {
 	log.Debug("Start to validate received block %x", block.HeaderHash)
 
 	// verify block header hash
 	blockHash := common.HeaderHash(block)
 	if !bytes.Equal(blockHash[:], block.HeaderHash[:]) {
 		log.Error("block header's hash %x, is not same with expected %x", blockHash, block.HeaderHash)
-		err := fmt.Errorf("block header's hash %x, is not same with expected %x", blockHash, block.HeaderHash)
+		err := common.NewVerifyError(common.ReasonInvalidHeaderHash, fmt.Errorf("block header's hash %x, is not same with expected %x", blockHash, block.HeaderHash))
 		filter.eventCenter.Notify(types.EventBlockVerifyFailed, err)
 		return err
 	}
 
+	// verify previous block is known
+	if _, err := filter.provider.GetBlockByHash(block.Header.PrevBlockHash); err != nil {
+		log.Error("Failed to get previous block, as: %v", err)
+		err := common.NewVerifyError(common.ReasonUnknownParent, fmt.Errorf("failed to get previous block, as:%v", err))
+		filter.eventCenter.Notify(types.EventBlockVerifyFailed, err)
+		return err
+	}
+	return nil
+}
+
+// do verify operation
+func (filter *BlockFilter) doValidate(block *types.Block) error {
+	// header hash is verified by precheck already
+	blockHash := block.HeaderHash
+
 	// retrieve previous world state
 	preBlkHash := block.Header.PrevBlockHash
-	bc, err := repository.NewRepositoryByBlockHash(preBlkHash)
+	state, err := filter.provider.StateAt(preBlkHash)
 	if err != nil {
 		log.Error("Failed to validate previous block, as: %v", err)
-		err := fmt.Errorf("failed to get previous block state, as:%v", err)
+		err := common.NewVerifyError(common.ReasonUnknownParent, fmt.Errorf("failed to get previous block state, as:%v", err))
 		filter.eventCenter.Notify(types.EventBlockVerifyFailed, err)
 		return err
 	}
 
-	currentHeight := bc.GetCurrentBlockHeight()
-	if currentHeight >= block.Header.Height {
-		log.Warn("Local block height %d is bigger than received block %x, height: %d", currentHeight, blockHash, block.Header.Height)
-		err := fmt.Errorf("Local block height %d is bigger than received block %x, height: %d ", currentHeight, blockHash, block.Header.Height)
-		filter.eventCenter.Notify(types.EventBlockExisted, err)
-		return err
+	if filter.forkChain != nil {
+		// blocks on side branches are accepted, only the known block is rejected
+		if _, err := filter.forkChain.GetBlockByHash(blockHash); err == nil {
+			log.Warn("Block %x at height %d exists already", blockHash, block.Header.Height)
+			err := common.NewVerifyError(common.ReasonBlockExisted, fmt.Errorf("block %x at height %d exists already", blockHash, block.Header.Height))
+			filter.eventCenter.Notify(types.EventBlockExisted, err)
+			return err
+		}
+	} else {
+		currentBlock, err := filter.provider.CurrentBlock()
+		if err != nil {
+			log.Error("Failed to get current block, as: %v", err)
+			err := common.NewVerifyError(common.ReasonStateUnavailable, fmt.Errorf("failed to get current block, as: %v", err))
+			filter.eventCenter.Notify(types.EventBlockVerifyFailed, err)
+			return err
+		}
+		currentHeight := currentBlock.Header.Height
+		if currentHeight >= block.Header.Height {
+			log.Warn("Local block height %d is bigger than received block %x, height: %d", currentHeight, blockHash, block.Header.Height)
+			err := common.NewVerifyError(common.ReasonBlockExisted, fmt.Errorf("Local block height %d is bigger than received block %x, height: %d ", currentHeight, blockHash, block.Header.Height))
+			filter.eventCenter.Notify(types.EventBlockExisted, err)
+			return err
+		}
+		// without fork handling, only the block extending the head is accepted
+		if block.Header.PrevBlockHash != currentBlock.HeaderHash {
+			log.Error("Previous block %x of block %x is not the head %x", block.Header.PrevBlockHash, blockHash, currentBlock.HeaderHash)
+			err := common.NewVerifyError(common.ReasonInvalidBlock, fmt.Errorf("wrong Block.Header.PrevBlockHash, expected %x, got %x", currentBlock.HeaderHash, block.Header.PrevBlockHash))
+			filter.eventCenter.Notify(types.EventBlockVerifyFailed, err)
+			return err
+		}
 	}
 
 	// verify block
-	blockValidator := getValidateWorker(bc, block, filter.verifySignature)
-	err = blockValidator.VerifyBlock()
+	receipts, err := state.VerifyBlock(block, &common.BlockVerifyConfig{
+		VerifySignature: filter.verifySignature,
+		Rules:           filter.rules,
+		Permissions:     filter.permissions,
+	})
 	if err != nil {
 		log.Error("Validate block failed, as %v", err)
-		err := fmt.Errorf("Validate block failed, as %v", err)
-		filter.eventCenter.Notify(types.EventBlockVerifyFailed, err)
-		return err
+		verifyErr := common.NewVerifyError(common.ReasonInvalidBlock, fmt.Errorf("Validate block failed, as %v", err))
+		switch err := err.(type) {
+		case *common.StateRootError:
+			// keep the expected and actual state root in the event payload
+			verifyErr = common.NewVerifyError(common.ReasonStateRootMismatch, err)
+		case *common.VerifyError:
+			// keep the reason, e.g. a tx forbidden by permissions
+			verifyErr = err
+		}
+		filter.eventCenter.Notify(types.EventBlockVerifyFailed, verifyErr)
+		return verifyErr
 	}
 
 	// write block to local database
-	return bc.WriteBlockWithReceipts(block, blockValidator.GetReceipts())
+	if filter.forkChain != nil {
+		err = filter.writeOnFork(state, block, receipts)
+	} else {
+		err = state.WriteBlock(block, receipts)
+	}
+	if err != nil {
+		log.Error("Failed to write block %x, as: %v", blockHash, err)
+		if common.ErrorReason(err) == "" {
+			err = common.NewVerifyError(common.ReasonWriteBlockFailed, fmt.Errorf("failed to write block %x, as: %v", blockHash, err))
+		}
+		filter.eventCenter.Notify(types.EventBlockVerifyFailed, err)
+		return err
+	}
+	return nil
 }
 
-// get validate worker by previous world state and block
-func getValidateWorker(bc *repository.Repository, block *types.Block, verifySignature bool) *Worker {
-	return NewWorker(bc, block, verifySignature)
+// get validate worker by the world state after parent block and block
+func getValidateWorker(bc *repository.Repository, parent *types.Block, block *types.Block, verifySignature bool,
+	rules *common.ChainRules, permissions *common.Permissions) *Worker {
+	worker := NewWorkerWithRules(bc, block, verifySignature, rules)
+	worker.parent = parent
+	worker.permissions = permissions
+	return worker
 }
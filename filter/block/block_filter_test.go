@@ -84,7 +84,7 @@ func TestBlockFilter_Verify(t *testing.T) {
 	monkey.PatchInstanceMethod(reflect.TypeOf(validateWorker), "GetReceipts", func(self *Worker) types.Receipts {
 		return types.Receipts{}
 	})
-	monkey.Patch(getValidateWorker, func(bc *repository.Repository, block *types.Block, verifySignature bool) *Worker {
+	monkey.Patch(getValidateWorker, func(bc *repository.Repository, parent *types.Block, block *types.Block, verifySignature bool, rules *filter.ChainRules, permissions *filter.Permissions) *Worker {
 		return validateWorker
 	})
 	assert.Nil(blockFilter.Verify(port.LocalInPortId, block), "PASS: verify valid block")
@@ -115,13 +115,33 @@ func TestBlockFilter_Verify2(t *testing.T) {
 	monkey.PatchInstanceMethod(reflect.TypeOf(validateWorker), "GetReceipts", func(self *Worker) types.Receipts {
 		return types.Receipts{}
 	})
-	monkey.Patch(getValidateWorker, func(bc *repository.Repository, block *types.Block, verifySignature bool) *Worker {
+	monkey.Patch(getValidateWorker, func(bc *repository.Repository, parent *types.Block, block *types.Block, verifySignature bool, rules *filter.ChainRules, permissions *filter.Permissions) *Worker {
 		return validateWorker
 	})
 	assert.NotNil(blockFilter.Verify(port.LocalInPortId, block), "PASS: verify invalid block")
 	assert.NotNil(blockFilter.Verify(port.RemoteInPortId, block), "PASS: verify invalid block")
 }
 
+// Test block with wrong state root is rejected with the mismatch detail
+func TestBlockFilter_VerifyStateRoot(t *testing.T) {
+	defer monkey.UnpatchAll()
+	assert := assert.New(t)
+	var blockFilter = NewBlockFilter(mockEventCenter(), true)
+
+	block := mockBlock()
+	rootErr := &filter.StateRootError{BlockHash: block.HeaderHash, Height: 1, Expected: MockHash}
+	var validateWorker *Worker
+	monkey.PatchInstanceMethod(reflect.TypeOf(validateWorker), "VerifyBlock", func(self *Worker) error {
+		return rootErr
+	})
+	monkey.Patch(getValidateWorker, func(bc *repository.Repository, parent *types.Block, block *types.Block, verifySignature bool, rules *filter.ChainRules, permissions *filter.Permissions) *Worker {
+		return validateWorker
+	})
+	err := blockFilter.Verify(port.RemoteInPortId, block)
+	assert.Equal(filter.ReasonStateRootMismatch, filter.ErrorReason(err))
+	assert.Equal(rootErr, err.(*filter.VerifyError).Err)
+}
+
 type eventCenter struct {
 }
 
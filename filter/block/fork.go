@@ -0,0 +1,159 @@
+package block
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/craft/types"
+	common "github.com/DSiSc/gossipswitch/filter"
+	"sort"
+)
+
+// DefaultMaxForkTips is the default max number of branch tips tracked by block filter.
+const DefaultMaxForkTips = 16
+
+// forkTips track the tips of the known branches, a tip is a block without known child.
+type forkTips struct {
+	size int
+	tips map[types.Hash]*types.Block
+}
+
+func newForkTips(size int) *forkTips {
+	if size <= 0 {
+		size = DefaultMaxForkTips
+	}
+	return &forkTips{
+		size: size,
+		tips: make(map[types.Hash]*types.Block),
+	}
+}
+
+// add block as a tip, its parent is no longer a tip. The lowest tip other than head is dropped if
+// there are too many tips.
+func (tips *forkTips) add(block *types.Block, head types.Hash) {
+	delete(tips.tips, block.Header.PrevBlockHash)
+	tips.tips[blockHash(block)] = block
+	for len(tips.tips) > tips.size {
+		var lowest *types.Block
+		var lowestHash types.Hash
+		for hash, tip := range tips.tips {
+			if hash != head && (lowest == nil || tip.Header.Height < lowest.Header.Height) {
+				lowest, lowestHash = tip, hash
+			}
+		}
+		if lowest == nil {
+			return
+		}
+		delete(tips.tips, lowestHash)
+	}
+}
+
+// list the tips from the highest to the lowest
+func (tips *forkTips) list() []*types.Block {
+	list := make([]*types.Block, 0, len(tips.tips))
+	for _, tip := range tips.tips {
+		list = append(list, tip)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Header.Height > list[j].Header.Height })
+	return list
+}
+
+// Tips return the tips of the branches seen by block filter from the highest to the lowest,
+// return nil if fork handling is disabled.
+func (filter *BlockFilter) Tips() []*types.Block {
+	if filter.tips == nil {
+		return nil
+	}
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+	return filter.tips.list()
+}
+
+// write the verified block to the fork chain. A block extending the head becomes the new head, a block on
+// a side branch is kept aside, and becomes the head by reorganisation if fork choice prefers it.
+func (filter *BlockFilter) writeOnFork(state common.BlockState, block *types.Block, receipts types.Receipts) error {
+	head, err := filter.forkChain.CurrentBlock()
+	if err != nil {
+		return common.NewVerifyError(common.ReasonStateUnavailable, fmt.Errorf("failed to get current block, as: %v", err))
+	}
+	headHash := blockHash(head)
+	filter.tips.add(head, headHash)
+	if block.Header.PrevBlockHash == headHash {
+		if err := state.WriteBlock(block, receipts); err != nil {
+			return err
+		}
+		filter.tips.add(block, blockHash(block))
+		return nil
+	}
+
+	if err := filter.forkChain.WriteSideBlock(block, receipts); err != nil {
+		return err
+	}
+	filter.tips.add(block, headHash)
+	if !filter.forkChoice.ReplaceHead(head, block) {
+		log.Info("Block %x at height %d is accepted on a side branch, head is %x at height %d", blockHash(block), block.Header.Height, headHash, head.Header.Height)
+		return nil
+	}
+	return filter.reorganize(head, block)
+}
+
+// make newHead the head of the local chain, and notify the reorganisation
+func (filter *BlockFilter) reorganize(oldHead, newHead *types.Block) error {
+	dropped, added, err := forkPath(filter.forkChain, oldHead, newHead)
+	if err != nil {
+		log.Error("Failed to find common ancestor of %x and %x, as: %v", blockHash(oldHead), blockHash(newHead), err)
+		return fmt.Errorf("failed to find common ancestor of %x and %x, as: %v", blockHash(oldHead), blockHash(newHead), err)
+	}
+	if err := filter.forkChain.SetHead(blockHash(newHead)); err != nil {
+		log.Error("Failed to set head to %x, as: %v", blockHash(newHead), err)
+		return fmt.Errorf("failed to set head to %x, as: %v", blockHash(newHead), err)
+	}
+	log.Info("Chain reorganized from %x at height %d to %x at height %d, drop %d blocks, add %d blocks",
+		blockHash(oldHead), oldHead.Header.Height, blockHash(newHead), newHead.Header.Height, len(dropped), len(added))
+	filter.eventCenter.Notify(common.EventChainReorganized, &common.Reorg{
+		OldHead: oldHead,
+		NewHead: newHead,
+		Dropped: dropped,
+		Added:   added,
+	})
+	return nil
+}
+
+// find the blocks leaving and joining the head branch when the head moves from oldHead to newHead.
+// dropped is ordered from oldHead down to the common ancestor, added from the common ancestor up to newHead,
+// the common ancestor is in neither.
+func forkPath(chain common.ChainReader, oldHead, newHead *types.Block) (dropped, added []*types.Block, err error) {
+	parent := func(block *types.Block) (*types.Block, error) {
+		return chain.GetBlockByHash(block.Header.PrevBlockHash)
+	}
+	oldBlock, newBlock := oldHead, newHead
+	for oldBlock.Header.Height > newBlock.Header.Height {
+		dropped = append(dropped, oldBlock)
+		if oldBlock, err = parent(oldBlock); err != nil {
+			return nil, nil, err
+		}
+	}
+	for newBlock.Header.Height > oldBlock.Header.Height {
+		added = append(added, newBlock)
+		if newBlock, err = parent(newBlock); err != nil {
+			return nil, nil, err
+		}
+	}
+	for blockHash(oldBlock) != blockHash(newBlock) {
+		if oldBlock.Header.Height == 0 {
+			return nil, nil, errors.New("no common ancestor")
+		}
+		dropped = append(dropped, oldBlock)
+		added = append(added, newBlock)
+		if oldBlock, err = parent(oldBlock); err != nil {
+			return nil, nil, err
+		}
+		if newBlock, err = parent(newBlock); err != nil {
+			return nil, nil, err
+		}
+	}
+	for i, j := 0, len(added)-1; i < j; i, j = i+1, j-1 {
+		added[i], added[j] = added[j], added[i]
+	}
+	return dropped, added, nil
+}
@@ -0,0 +1,184 @@
+package block
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/port"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+// event center recording the notified values
+type recordEventCenter struct {
+	eventCenter
+	lock   sync.Mutex
+	values map[types.EventType][]interface{}
+}
+
+func (center *recordEventCenter) Notify(eventType types.EventType, value interface{}) (err error) {
+	center.lock.Lock()
+	defer center.lock.Unlock()
+	if center.values == nil {
+		center.values = make(map[types.EventType][]interface{})
+	}
+	center.values[eventType] = append(center.values[eventType], value)
+	return nil
+}
+
+func (center *recordEventCenter) notified(eventType types.EventType) []interface{} {
+	center.lock.Lock()
+	defer center.lock.Unlock()
+	return center.values[eventType]
+}
+
+// mock a block on a side branch extending parent
+func mockSideBlock(parent *types.Block) *types.Block {
+	block := mockChildBlock(parent)
+	block.Header.Timestamp = 1
+	block.HeaderHash = filter.HeaderHash(block)
+	return block
+}
+
+// Test accept side branch blocks and reorganize by the longest chain
+func TestBlockFilter_VerifyFork(t *testing.T) {
+	assert := assert.New(t)
+	genesis := mockMemoryGenesis()
+	provider := NewMemoryStateProvider(genesis)
+	center := &recordEventCenter{}
+	blockFilter, err := NewBlockFilterWithConfig(center, &config.SwitchConfig{
+		StateProvider: provider,
+		ForkHandling:  true,
+	})
+	assert.Nil(err)
+
+	a1 := mockChildBlock(genesis)
+	a2 := mockChildBlock(a1)
+	assert.Nil(blockFilter.Verify(port.RemoteInPortId, a1))
+	assert.Nil(blockFilter.Verify(port.RemoteInPortId, a2))
+
+	// side branch blocks are accepted without changing the head
+	b1 := mockSideBlock(genesis)
+	b2 := mockChildBlock(b1)
+	assert.Nil(blockFilter.Verify(port.RemoteInPortId, b1))
+	assert.Nil(blockFilter.Verify(port.RemoteInPortId, b2))
+	head, _ := provider.CurrentBlock()
+	assert.Equal(a2, head)
+	assert.Len(blockFilter.Tips(), 2)
+	assert.Empty(center.notified(filter.EventChainReorganized))
+
+	// known block is rejected
+	err = blockFilter.Verify(port.RemoteInPortId, b1)
+	assert.Equal(filter.ReasonBlockExisted, filter.ErrorReason(err))
+
+	// invalid side branch block is rejected
+	invalid := mockSideBlock(b1)
+	invalid.Header.Height = 5
+	invalid.HeaderHash = filter.HeaderHash(invalid)
+	err = blockFilter.Verify(port.RemoteInPortId, invalid)
+	assert.Equal(filter.ReasonInvalidBlock, filter.ErrorReason(err))
+
+	// the longer side branch becomes the head branch
+	b3 := mockChildBlock(b2)
+	assert.Nil(blockFilter.Verify(port.RemoteInPortId, b3))
+	head, _ = provider.CurrentBlock()
+	assert.Equal(b3, head)
+	reorgs := center.notified(filter.EventChainReorganized)
+	assert.Len(reorgs, 1)
+	assert.Equal(&filter.Reorg{
+		OldHead: a2,
+		NewHead: b3,
+		Dropped: []*types.Block{a2, a1},
+		Added:   []*types.Block{b1, b2, b3},
+	}, reorgs[0])
+	assert.Equal([]*types.Block{b3, a2}, blockFilter.Tips())
+
+	// extending the new head does not reorganize
+	b4 := mockChildBlock(b3)
+	assert.Nil(blockFilter.Verify(port.RemoteInPortId, b4))
+	assert.Len(center.notified(filter.EventChainReorganized), 1)
+}
+
+// Test custom fork choice
+func TestBlockFilter_VerifyForkChoice(t *testing.T) {
+	assert := assert.New(t)
+	genesis := mockMemoryGenesis()
+	provider := NewMemoryStateProvider(genesis)
+	center := &recordEventCenter{}
+	blockFilter, err := NewBlockFilterWithConfig(center, &config.SwitchConfig{
+		StateProvider: provider,
+		ForkHandling:  true,
+		ForkChoice: filter.ForkChoiceFunc(func(head, tip *types.Block) bool {
+			return tip.Header.Timestamp > head.Header.Timestamp
+		}),
+	})
+	assert.Nil(err)
+
+	a1 := mockChildBlock(genesis)
+	a2 := mockChildBlock(a1)
+	assert.Nil(blockFilter.Verify(port.RemoteInPortId, a1))
+	assert.Nil(blockFilter.Verify(port.RemoteInPortId, a2))
+	b1 := mockSideBlock(genesis)
+	assert.Nil(blockFilter.Verify(port.RemoteInPortId, b1))
+	head, _ := provider.CurrentBlock()
+	assert.Equal(b1, head)
+	assert.Equal(&filter.Reorg{
+		OldHead: a2,
+		NewHead: b1,
+		Dropped: []*types.Block{a2, a1},
+		Added:   []*types.Block{b1},
+	}, center.notified(filter.EventChainReorganized)[0])
+}
+
+// Test fork handling is refused if the state provider can not keep side branches
+func TestNewBlockFilterWithConfig_ForkUnsupported(t *testing.T) {
+	assert := assert.New(t)
+	blockFilter, err := NewBlockFilterWithConfig(mockEventCenter(), &config.SwitchConfig{ForkHandling: true})
+	assert.NotNil(err)
+	assert.Nil(blockFilter)
+}
+
+// Test side branch blocks are rejected if fork handling is disabled
+func TestBlockFilter_VerifyWithoutFork(t *testing.T) {
+	assert := assert.New(t)
+	genesis := mockMemoryGenesis()
+	provider := NewMemoryStateProvider(genesis)
+	blockFilter, err := NewBlockFilterWithConfig(mockEventCenter(), &config.SwitchConfig{StateProvider: provider})
+	assert.Nil(err)
+	assert.Nil(blockFilter.Tips())
+
+	a1 := mockChildBlock(genesis)
+	assert.Nil(blockFilter.Verify(port.RemoteInPortId, a1))
+	b1 := mockSideBlock(genesis)
+	err = blockFilter.Verify(port.RemoteInPortId, b1)
+	assert.Equal(filter.ReasonBlockExisted, filter.ErrorReason(err))
+	b2 := mockChildBlock(b1)
+	provider.WriteSideBlock(b1, nil)
+	err = blockFilter.Verify(port.RemoteInPortId, b2)
+	assert.Equal(filter.ReasonInvalidBlock, filter.ErrorReason(err))
+}
+
+// Test track fork tips
+func TestForkTips(t *testing.T) {
+	assert := assert.New(t)
+	genesis := mockMemoryGenesis()
+	tips := newForkTips(2)
+	a1 := mockChildBlock(genesis)
+	b1 := mockSideBlock(genesis)
+	a2 := mockChildBlock(a1)
+	tips.add(genesis, genesis.HeaderHash)
+	tips.add(a1, a1.HeaderHash)
+	tips.add(b1, a1.HeaderHash)
+	assert.Len(tips.list(), 2)
+	assert.Contains(tips.list(), a1)
+	assert.Contains(tips.list(), b1)
+	tips.add(a2, b1.HeaderHash)
+	assert.Equal([]*types.Block{a2, b1}, tips.list())
+	c2 := mockSideBlock(a1)
+	c2.Header.Timestamp = 2
+	c2.HeaderHash = filter.HeaderHash(c2)
+	tips.add(c2, b1.HeaderHash)
+	assert.Len(tips.list(), 2)
+	assert.Contains(tips.list(), b1)
+}
@@ -0,0 +1,133 @@
+package block
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/filter"
+	"sync"
+)
+
+// MemoryStateProvider is an in-memory filter.ForkChain for simulation and testing. It keeps blocks and
+// receipts in memory, and verifies block header, tx signatures and permissions, but does not execute txs.
+type MemoryStateProvider struct {
+	lock     sync.RWMutex
+	blocks   map[types.Hash]*types.Block
+	receipts map[types.Hash]types.Receipts
+	head     *types.Block
+}
+
+// NewMemoryStateProvider create an in-memory state provider with the genesis block.
+func NewMemoryStateProvider(genesis *types.Block) *MemoryStateProvider {
+	return &MemoryStateProvider{
+		blocks:   map[types.Hash]*types.Block{blockHash(genesis): genesis},
+		receipts: make(map[types.Hash]types.Receipts),
+		head:     genesis,
+	}
+}
+
+// CurrentBlock return the head block.
+func (provider *MemoryStateProvider) CurrentBlock() (*types.Block, error) {
+	provider.lock.RLock()
+	defer provider.lock.RUnlock()
+	return provider.head, nil
+}
+
+// GetBlockByHash return the block with hash.
+func (provider *MemoryStateProvider) GetBlockByHash(hash types.Hash) (*types.Block, error) {
+	provider.lock.RLock()
+	defer provider.lock.RUnlock()
+	block, ok := provider.blocks[hash]
+	if !ok {
+		return nil, fmt.Errorf("block %x not found", hash)
+	}
+	return block, nil
+}
+
+// GetReceipts return the receipts of the block with hash.
+func (provider *MemoryStateProvider) GetReceipts(hash types.Hash) types.Receipts {
+	provider.lock.RLock()
+	defer provider.lock.RUnlock()
+	return provider.receipts[hash]
+}
+
+// WriteSideBlock write the block of a side branch with receipts, the head is not changed.
+func (provider *MemoryStateProvider) WriteSideBlock(block *types.Block, receipts types.Receipts) error {
+	if block.Header == nil {
+		return errors.New("block header is nil")
+	}
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	hash := blockHash(block)
+	provider.blocks[hash] = block
+	provider.receipts[hash] = receipts
+	return nil
+}
+
+// SetHead make the block with hash the head.
+func (provider *MemoryStateProvider) SetHead(hash types.Hash) error {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	block, ok := provider.blocks[hash]
+	if !ok {
+		return fmt.Errorf("block %x not found", hash)
+	}
+	provider.head = block
+	return nil
+}
+
+// StateAt return the state after the block with hash.
+func (provider *MemoryStateProvider) StateAt(hash types.Hash) (filter.BlockState, error) {
+	parent, err := provider.GetBlockByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryState{provider: provider, parent: parent}, nil
+}
+
+// memoryState is the state after parent block
+type memoryState struct {
+	provider *MemoryStateProvider
+	parent   *types.Block
+}
+
+// VerifyBlock verify block header against parent block, tx signatures and permissions.
+func (state *memoryState) VerifyBlock(block *types.Block, verifyConfig *filter.BlockVerifyConfig) (types.Receipts, error) {
+	worker := NewWorkerWithRules(nil, block, verifyConfig.VerifySignature, verifyConfig.Rules)
+	if err := worker.verifyHeader(state.parent, state.parent.Header.Height); err != nil {
+		return nil, err
+	}
+	if verifyConfig.VerifySignature {
+		if err := worker.VerifyTrsSignatures(); err != nil {
+			return nil, err
+		}
+	}
+	for _, tx := range block.Transactions {
+		if err := verifyConfig.Permissions.Check(tx); err != nil {
+			return nil, err
+		}
+	}
+	return types.Receipts{}, nil
+}
+
+// WriteBlock write block with receipts, block becomes the head if it is higher than the head.
+func (state *memoryState) WriteBlock(block *types.Block, receipts types.Receipts) error {
+	provider := state.provider
+	if err := provider.WriteSideBlock(block, receipts); err != nil {
+		return err
+	}
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	if block.Header.Height > provider.head.Header.Height {
+		provider.head = block
+	}
+	return nil
+}
+
+// get block's hash, block.HeaderHash is used if it is set
+func blockHash(block *types.Block) types.Hash {
+	if !(block.HeaderHash == types.Hash{}) {
+		return block.HeaderHash
+	}
+	return filter.HeaderHash(block)
+}
@@ -0,0 +1,142 @@
+package block
+
+import (
+	"errors"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/port"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// mock a block extending parent
+func mockChildBlock(parent *types.Block) *types.Block {
+	block := &types.Block{
+		Header: &types.Header{
+			ChainID:       parent.Header.ChainID,
+			PrevBlockHash: parent.HeaderHash,
+			TxRoot:        GetTxsRoot(nil),
+			Height:        parent.Header.Height + 1,
+		},
+	}
+	block.HeaderHash = filter.HeaderHash(block)
+	return block
+}
+
+// mock a genesis block
+func mockMemoryGenesis() *types.Block {
+	genesis := &types.Block{
+		Header: &types.Header{ChainID: 1},
+	}
+	genesis.HeaderHash = filter.HeaderHash(genesis)
+	return genesis
+}
+
+// Test verify blocks against in-memory state provider
+func TestBlockFilter_VerifyWithMemoryProvider(t *testing.T) {
+	assert := assert.New(t)
+	genesis := mockMemoryGenesis()
+	provider := NewMemoryStateProvider(genesis)
+	blockFilter, err := NewBlockFilterWithConfig(mockEventCenter(), &config.SwitchConfig{StateProvider: provider})
+	assert.Nil(err)
+
+	block1 := mockChildBlock(genesis)
+	assert.Nil(blockFilter.Verify(port.RemoteInPortId, block1))
+	head, err := provider.CurrentBlock()
+	assert.Nil(err)
+	assert.Equal(block1, head)
+	assert.NotNil(provider.GetReceipts(block1.HeaderHash))
+
+	// block exists already
+	err = blockFilter.Verify(port.RemoteInPortId, block1)
+	assert.Equal(filter.ReasonBlockExisted, filter.ErrorReason(err))
+
+	// parent is unknown
+	block3 := mockChildBlock(mockChildBlock(block1))
+	err = blockFilter.Verify(port.RemoteInPortId, block3)
+	assert.Equal(filter.ReasonUnknownParent, filter.ErrorReason(err))
+
+	// wrong height
+	block2 := mockChildBlock(block1)
+	block2.Header.Height = 5
+	block2.HeaderHash = filter.HeaderHash(block2)
+	err = blockFilter.Verify(port.RemoteInPortId, block2)
+	assert.Equal(filter.ReasonInvalidBlock, filter.ErrorReason(err))
+}
+
+// Test in-memory state provider
+func TestMemoryStateProvider(t *testing.T) {
+	assert := assert.New(t)
+	genesis := mockMemoryGenesis()
+	provider := NewMemoryStateProvider(genesis)
+	block, err := provider.GetBlockByHash(genesis.HeaderHash)
+	assert.Nil(err)
+	assert.Equal(genesis, block)
+	_, err = provider.GetBlockByHash(MockHash)
+	assert.NotNil(err)
+	_, err = provider.StateAt(MockHash)
+	assert.NotNil(err)
+
+	state, err := provider.StateAt(genesis.HeaderHash)
+	assert.Nil(err)
+	permissions, _ := filter.NewPermissions(&filter.PermissionRules{
+		Deployers: filter.AccessList{Deny: []string{"0x0000000000000000000000000000000000000000"}},
+	})
+	block1 := mockChildBlock(genesis)
+	block1.Transactions = []*types.Transaction{{Data: types.TxData{From: &types.Address{}}}}
+	block1.Header.TxRoot = GetTxsRoot(block1.Transactions)
+	block1.HeaderHash = filter.HeaderHash(block1)
+	_, err = state.VerifyBlock(block1, &filter.BlockVerifyConfig{Permissions: permissions})
+	assert.Equal(filter.ReasonDeployNotPermitted, filter.ErrorReason(err))
+}
+
+// state provider failing to read the head
+type brokenStateProvider struct {
+	*MemoryStateProvider
+}
+
+func (provider *brokenStateProvider) CurrentBlock() (*types.Block, error) {
+	return nil, errors.New("database is closed")
+}
+
+// Test block is rejected with typed error if the local chain is unavailable
+func TestBlockFilter_VerifyStateUnavailable(t *testing.T) {
+	assert := assert.New(t)
+	genesis := mockMemoryGenesis()
+	provider := &brokenStateProvider{NewMemoryStateProvider(genesis)}
+	blockFilter, err := NewBlockFilterWithConfig(mockEventCenter(), &config.SwitchConfig{StateProvider: provider})
+	assert.Nil(err)
+	err = blockFilter.Verify(port.RemoteInPortId, mockChildBlock(genesis))
+	assert.Equal(filter.ReasonStateUnavailable, filter.ErrorReason(err))
+	assert.False(filter.IsPermanent(err))
+}
+
+// Test senders of a junk block's txs are not recovered
+func TestBlockFilter_VerifyJunkBlockSkipsRecovery(t *testing.T) {
+	assert := assert.New(t)
+	genesis := mockMemoryGenesis()
+	provider := NewMemoryStateProvider(genesis)
+	blockFilter, err := NewBlockFilterWithConfig(mockEventCenter(), &config.SwitchConfig{
+		VerifySignature: true,
+		StateProvider:   provider,
+	})
+	assert.Nil(err)
+	cached := filter.DefaultSenderCache.Len()
+
+	// invalid header hash
+	block := mockChildBlock(genesis)
+	block.Transactions = []*types.Transaction{mockTrx(), mockTrx()}
+	block.HeaderHash = MockHash
+	err = blockFilter.Verify(port.RemoteInPortId, block)
+	assert.Equal(filter.ReasonInvalidHeaderHash, filter.ErrorReason(err))
+	assert.Equal(cached, filter.DefaultSenderCache.Len())
+
+	// unknown parent
+	block = mockChildBlock(mockChildBlock(genesis))
+	block.Transactions = []*types.Transaction{mockTrx(), mockTrx()}
+	block.HeaderHash = filter.HeaderHash(block)
+	err = blockFilter.Verify(port.RemoteInPortId, block)
+	assert.Equal(filter.ReasonUnknownParent, filter.ErrorReason(err))
+	assert.Equal(cached, filter.DefaultSenderCache.Len())
+}
@@ -0,0 +1,73 @@
+package block
+
+import (
+	"errors"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/repository"
+)
+
+// RepositoryStateProvider is the filter.StateProvider backed by repository, which is the default
+// state provider of block filter. It is not a filter.ForkChain, as repository always writes a block on top
+// of its head and can not switch head, so fork handling is unsupported with it.
+type RepositoryStateProvider struct{}
+
+// NewRepositoryStateProvider create a state provider backed by repository.
+func NewRepositoryStateProvider() *RepositoryStateProvider {
+	return &RepositoryStateProvider{}
+}
+
+// CurrentBlock return the head block of the local chain.
+func (provider *RepositoryStateProvider) CurrentBlock() (*types.Block, error) {
+	bc, err := repository.NewLatestStateRepository()
+	if err != nil {
+		return nil, err
+	}
+	block := bc.GetCurrentBlock()
+	if block == nil {
+		return nil, errors.New("current block not found")
+	}
+	return block, nil
+}
+
+// GetBlockByHash return the block with hash.
+func (provider *RepositoryStateProvider) GetBlockByHash(hash types.Hash) (*types.Block, error) {
+	bc, err := repository.NewLatestStateRepository()
+	if err != nil {
+		return nil, err
+	}
+	return bc.GetBlockByHash(hash)
+}
+
+// StateAt return the world state after the block with hash.
+func (provider *RepositoryStateProvider) StateAt(blockHash types.Hash) (filter.BlockState, error) {
+	bc, err := repository.NewRepositoryByBlockHash(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := bc.GetBlockByHash(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	return &repositoryState{bc: bc, parent: parent}, nil
+}
+
+// repositoryState verify block by executing its txs on repository
+type repositoryState struct {
+	bc     *repository.Repository
+	parent *types.Block
+}
+
+// VerifyBlock verify block by worker.
+func (state *repositoryState) VerifyBlock(block *types.Block, verifyConfig *filter.BlockVerifyConfig) (types.Receipts, error) {
+	worker := getValidateWorker(state.bc, state.parent, block, verifyConfig.VerifySignature, verifyConfig.Rules, verifyConfig.Permissions)
+	if err := worker.VerifyBlock(); err != nil {
+		return nil, err
+	}
+	return worker.GetReceipts(), nil
+}
+
+// WriteBlock write block with receipts to repository.
+func (state *repositoryState) WriteBlock(block *types.Block, receipts types.Receipts) error {
+	return state.bc.WriteBlockWithReceipts(block, receipts)
+}
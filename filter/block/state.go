@@ -5,15 +5,20 @@ import (
 	"github.com/DSiSc/craft/log"
 	"github.com/DSiSc/craft/types"
 	evmNg "github.com/DSiSc/evm-NG"
+	"github.com/DSiSc/gossipswitch/filter"
 	"github.com/DSiSc/repository"
 	evmCommon "github.com/DSiSc/validator/common"
 	"github.com/DSiSc/validator/worker/common"
 	wasmExec "github.com/DSiSc/wasm/exec"
 	wasmModule "github.com/DSiSc/wasm/wasm"
-	"math"
 	"math/big"
 )
 
+var (
+	errInsufficientBalanceForGas = errors.New("insufficient balance to pay for gas")
+	errOutOfGas                  = errors.New("out of gas")
+)
+
 type StateTransition struct {
 	gp         *common.GasPool
 	tx         *types.Transaction
@@ -38,20 +43,22 @@ func NewStateTransition(author types.Address, header *types.Header, chain *repos
 	} else {
 		receive = *trx.Data.Recipient
 	}
+	gasPrice := trx.Data.Price
+	if gasPrice == nil {
+		gasPrice = new(big.Int)
+	}
 	return &StateTransition{
-		author:     author,
-		gp:         gp,
-		tx:         trx,
-		from:       *trx.Data.From,
-		to:         receive,
-		gasPrice:   trx.Data.Price,
-		value:      trx.Data.Amount,
-		data:       trx.Data.Payload,
-		state:      chain,
-		gas:        trx.Data.GasLimit,
-		initialGas: math.MaxUint64,
-		nonce:      trx.Data.AccountNonce,
-		header:     header,
+		author:   author,
+		gp:       gp,
+		tx:       trx,
+		from:     *trx.Data.From,
+		to:       receive,
+		gasPrice: gasPrice,
+		value:    trx.Data.Amount,
+		data:     trx.Data.Payload,
+		state:    chain,
+		nonce:    trx.Data.AccountNonce,
+		header:   header,
 	}
 }
 
@@ -67,27 +74,65 @@ func ApplyTransaction(author types.Address, header *types.Header, chain *reposit
 
 // TransitionDb will transition the state by applying the current message and
 // returning the result including the used gas. It returns an error if failed.
-// An error indicates a consensus issue.
+// An error indicates a consensus issue. A tx failed in VM, e.g. out of gas or reverted, is not
+// a consensus issue, it consumes the gas and is reported by failed.
+//
+// The fee of the used gas is charged to sender but not credited to anyone, i.e. it is burnt, as
+// block producer does not pay fees to block author either. Crediting it here would make the state
+// root differ from the one produced.
 func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, failed bool, err error, address types.Address) {
 	if err = st.preCheck(); err != nil {
 		return
 	}
+	// pay intrinsic gas
+	gas, err := filter.IntrinsicGas(st.data, st.tx.Data.Recipient == nil)
+	if err != nil {
+		return nil, 0, false, err, address
+	}
+	if err = st.useGas(gas); err != nil {
+		return nil, 0, false, err, address
+	}
+	var vmerr error
 	if st.isWasmContract(st.tx) {
-		ret, address, st.gas, err = st.execWasmContract()
+		ret, address, st.gas, vmerr = st.execWasmContract()
 	} else {
-		var vmerr error
 		ret, address, st.gas, vmerr = st.execSolidityContract()
-		if vmerr != nil {
-			log.Debug("VM returned with error", "err", vmerr)
-			// The only possible consensus-error would be if there wasn't
-			// sufficient balance to make the transfer happen. The first
-			// balance transfer may never fail.
-			if vmerr == evmNg.ErrInsufficientBalance {
-				return ret, 0, false, vmerr, address
-			}
+	}
+	if vmerr != nil {
+		log.Debug("VM returned with error %v", vmerr)
+		// The only possible consensus-error would be if there wasn't
+		// sufficient balance to make the transfer happen. The first
+		// balance transfer may never fail.
+		if vmerr == evmNg.ErrInsufficientBalance {
+			return ret, 0, false, vmerr, address
 		}
 	}
-	return ret, st.gasUsed(), err != nil, err, address
+	st.refundGas()
+	return ret, st.gasUsed(), vmerr != nil, nil, address
+}
+
+// useGas deducts amount from the gas left
+func (st *StateTransition) useGas(amount uint64) error {
+	if st.gas < amount {
+		return errOutOfGas
+	}
+	st.gas -= amount
+	return nil
+}
+
+// buyGas take tx's gas limit from block gas pool, and charge sender gas limit * gas price in advance.
+func (st *StateTransition) buyGas() error {
+	mgval := new(big.Int).Mul(new(big.Int).SetUint64(st.tx.Data.GasLimit), st.gasPrice)
+	if st.state.GetBalance(st.from).Cmp(mgval) < 0 {
+		return errInsufficientBalanceForGas
+	}
+	if err := st.gp.SubGas(st.tx.Data.GasLimit); err != nil {
+		return err
+	}
+	st.gas += st.tx.Data.GasLimit
+	st.initialGas = st.tx.Data.GasLimit
+	st.state.SubBalance(st.from, mgval)
+	return nil
 }
 
 func (st *StateTransition) refundGas() {
@@ -112,7 +157,7 @@ func (st *StateTransition) gasUsed() uint64 {
 	return st.initialGas - st.gas
 }
 
-// check tx's nonce
+// check tx's nonce and buy gas
 func (st *StateTransition) preCheck() error {
 	// Make sure this transaction's nonce is correct.
 	nonce := st.state.GetNonce(st.from)
@@ -121,7 +166,7 @@ func (st *StateTransition) preCheck() error {
 	} else if nonce > st.nonce {
 		return errors.New("nonce too high")
 	}
-	return nil
+	return st.buyGas()
 }
 
 // check whether the tx Recipient is wasm contract
@@ -138,28 +183,16 @@ func (st *StateTransition) isWasmContract(tx *types.Transaction) bool {
 func (st *StateTransition) execSolidityContract() (ret []byte, contractAddr types.Address, leftOverGas uint64, err error) {
 	from := *st.tx.Data.From
 	sender := evmCommon.NewRefAddress(from)
-	//homestead := st.evm.ChainConfig().IsHomestead(st.evm.BlockNumber)
 	contractCreation := st.tx.Data.Recipient == nil
-	/*
-		// Pay intrinsic gas
-		gas, err := IntrinsicGas(st.data, contractCreation, homestead)
-		if err != nil {
-			return nil, 0, false, err
-		}
-		if err = st.useGas(gas); err != nil {
-			return nil, 0, false, err
-		}
-	*/
 	context := evmNg.NewEVMContext(*st.tx, st.header, st.state, st.author)
 	evm := evmNg.NewEVM(context, st.state)
 
 	if contractCreation {
-		// ret, _, st.gas, vmerr = evm.Create(sender, st.data, st.gas, st.value)
-		ret, contractAddr, leftOverGas, err = evm.Create(sender, st.data, math.MaxUint64, st.value)
+		ret, contractAddr, leftOverGas, err = evm.Create(sender, st.data, st.gas, st.value)
 	} else {
 		// Increment the nonce for the next transaction
 		st.state.SetNonce(from, st.state.GetNonce(sender.Address())+1)
-		ret, leftOverGas, err = evm.Call(sender, st.to, st.data, math.MaxUint64, st.value)
+		ret, leftOverGas, err = evm.Call(sender, st.to, st.data, st.gas, st.value)
 	}
 	return ret, contractAddr, leftOverGas, err
 }
@@ -169,10 +202,10 @@ func (st *StateTransition) execWasmContract() (ret []byte, contractAddr types.Ad
 	wvm := wasmExec.NewVM(context, st.state)
 	contractCreation := st.tx.Data.Recipient == nil
 	if contractCreation {
-		ret, contractAddr, leftOverGas, err = wvm.Create(*st.tx.Data.From, st.data, math.MaxUint64, st.value)
+		ret, contractAddr, leftOverGas, err = wvm.Create(*st.tx.Data.From, st.data, st.gas, st.value)
 	} else {
 		st.state.SetNonce(*st.tx.Data.From, st.state.GetNonce(*st.tx.Data.From)+1)
-		ret, leftOverGas, err = wvm.Call(*st.tx.Data.From, *st.tx.Data.Recipient, st.data, math.MaxUint64, st.value)
+		ret, leftOverGas, err = wvm.Call(*st.tx.Data.From, *st.tx.Data.Recipient, st.data, st.gas, st.value)
 	}
 	return ret, contractAddr, leftOverGas, err
 }
@@ -3,12 +3,14 @@ package block
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"github.com/DSiSc/craft/types"
 	"github.com/DSiSc/evm-NG"
-	"github.com/DSiSc/evm-NG/common/math"
+	"github.com/DSiSc/gossipswitch/filter"
 	"github.com/DSiSc/monkey"
 	"github.com/DSiSc/repository"
 	"github.com/DSiSc/validator/worker/common"
+	wasmExec "github.com/DSiSc/wasm/exec"
 	"github.com/stretchr/testify/assert"
 	"math/big"
 	"reflect"
@@ -64,7 +66,7 @@ func mockTrx() *types.Transaction {
 		Data: types.TxData{
 			AccountNonce: 0,
 			Price:        new(big.Int).SetUint64(10),
-			GasLimit:     100,
+			GasLimit:     100000,
 			Recipient:    to,
 			From:         from,
 			Amount:       new(big.Int).SetUint64(50),
@@ -86,7 +88,7 @@ func TestNewStateTransition(t *testing.T) {
 func TestStateTransition_TransitionDb(t *testing.T) {
 	// test carets contract
 	bc := &repository.Repository{}
-	var gp = common.GasPool(10000)
+	var gp = common.GasPool(1000000)
 	state = NewStateTransition(author, MockBlock.Header, bc, mockTrx(), &gp)
 	state.tx.Data.Recipient = nil
 	var evmd *evm.EVM
@@ -94,7 +96,7 @@ func TestStateTransition_TransitionDb(t *testing.T) {
 		return to[:10], contractAddress, 0, evm.ErrInsufficientBalance
 	})
 	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetBalance", func(*repository.Repository, types.Address) *big.Int {
-		return new(big.Int).SetUint64(1000)
+		return new(big.Int).SetUint64(10000000)
 	})
 	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "SubBalance", func(*repository.Repository, types.Address, *big.Int) {
 		return
@@ -115,14 +117,14 @@ func TestStateTransition_TransitionDb1(t *testing.T) {
 	defer monkey.UnpatchAll()
 	// test transfer token
 	bc := &repository.Repository{}
-	var gp = common.GasPool(10000)
+	var gp = common.GasPool(1000000)
 	state = NewStateTransition(author, MockBlock.Header, bc, mockTrx(), &gp)
 	var evmd *evm.EVM
 	monkey.PatchInstanceMethod(reflect.TypeOf(evmd), "Create", func(*evm.EVM, evm.ContractRef, []byte, uint64, *big.Int) ([]byte, types.Address, uint64, error) {
 		return to[:10], contractAddress, 0, evm.ErrInsufficientBalance
 	})
 	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetBalance", func(*repository.Repository, types.Address) *big.Int {
-		return new(big.Int).SetUint64(1000)
+		return new(big.Int).SetUint64(10000000)
 	})
 	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "SubBalance", func(*repository.Repository, types.Address, *big.Int) {
 		return
@@ -134,17 +136,28 @@ func TestStateTransition_TransitionDb1(t *testing.T) {
 		assert.Equal(t, uint64(1), n)
 		return
 	})
-	monkey.PatchInstanceMethod(reflect.TypeOf(evmd), "Call", func(*evm.EVM, evm.ContractRef, types.Address, []byte, uint64, *big.Int) ([]byte, uint64, error) {
-		return []byte{0}, math.MaxUint64, nil
+	monkey.PatchInstanceMethod(reflect.TypeOf(evmd), "Call", func(_ *evm.EVM, _ evm.ContractRef, _ types.Address, _ []byte, gas uint64, _ *big.Int) ([]byte, uint64, error) {
+		return []byte{0}, gas, nil
 	})
 	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetCode", func(*repository.Repository, types.Address) []byte {
 		return []byte{}
 	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetRefund", func(*repository.Repository) uint64 {
+		return 0
+	})
+	var paid []*big.Int
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "AddBalance", func(_ *repository.Repository, _ types.Address, amount *big.Int) {
+		paid = append(paid, amount)
+	})
 	ret, used, ok, err, _ := state.TransitionDb()
 	assert.Equal(t, nil, err)
 	assert.Equal(t, ok, false)
-	assert.Equal(t, uint64(0), used)
+	intrinsicGas, _ := filter.IntrinsicGas(to[:10], false)
+	assert.Equal(t, intrinsicGas, used)
 	assert.Equal(t, []byte{0}, ret)
+	// only the gas left is refunded to sender
+	assert.Equal(t, []*big.Int{new(big.Int).SetUint64((100000 - intrinsicGas) * 10)}, paid)
+	assert.Equal(t, uint64(1000000-intrinsicGas), gp.Gas())
 
 	state.nonce = 100
 	ret, used, ok, err, _ = state.TransitionDb()
@@ -160,7 +173,7 @@ func TestStateTransition_TransitionDb1(t *testing.T) {
 func TestStateTransition_TransitionDb2(t *testing.T) {
 	// test carets contract
 	bc := &repository.Repository{}
-	var gp = common.GasPool(10000)
+	var gp = common.GasPool(1000000)
 	tx := mockTrx()
 	code, _ := hex.DecodeString("0061736d0100000001070160027f7f017f03020100070801046961646400000a09010700200020016a0b")
 	tx.Data.Payload = code
@@ -171,7 +184,7 @@ func TestStateTransition_TransitionDb2(t *testing.T) {
 		return to[:10], contractAddress, 0, evm.ErrInsufficientBalance
 	})
 	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetBalance", func(*repository.Repository, types.Address) *big.Int {
-		return new(big.Int).SetUint64(1000)
+		return new(big.Int).SetUint64(10000000)
 	})
 	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "SubBalance", func(*repository.Repository, types.Address, *big.Int) {
 		return
@@ -190,10 +203,14 @@ func TestStateTransition_TransitionDb2(t *testing.T) {
 	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetCodeHash", func(*repository.Repository, types.Address) types.Hash {
 		return types.Hash{}
 	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetRefund", func(*repository.Repository) uint64 {
+		return 0
+	})
 	_, used, ok, err, address := state.TransitionDb()
 	assert.Nil(t, err)
 	assert.Equal(t, ok, false)
-	assert.Equal(t, uint64(0), used)
+	intrinsicGas, _ := filter.IntrinsicGas(code, true)
+	assert.Equal(t, intrinsicGas, used)
 	assert.Equal(t, wasmContractAddress, address)
 	monkey.UnpatchAll()
 }
@@ -203,14 +220,14 @@ func TestStateTransition_TransitionDb3(t *testing.T) {
 	defer monkey.UnpatchAll()
 	// test carets contract
 	bc := &repository.Repository{}
-	var gp = common.GasPool(10000)
+	var gp = common.GasPool(1000000)
 	tx := mockTrx()
 	tx.Data.Recipient = &wasmContractAddress
 	code, _ := hex.DecodeString("0061736d01000000018c808080000260017f017f60027f7f017f028e808080000103656e76066d616c6c6f6300000382808080000101048480808000017000000583808080000100010681808080000007938080800002066d656d6f7279020006696e766f6b6500010a998080800001938080800001017f41021000220241c8d2013b000020020b")
 	tx.Data.Payload, _ = json.Marshal([]string{"Hi", "Bob"})
 	state = NewStateTransition(author, MockBlock.Header, bc, tx, &gp)
 	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetBalance", func(*repository.Repository, types.Address) *big.Int {
-		return new(big.Int).SetUint64(1000)
+		return new(big.Int).SetUint64(10000000)
 	})
 	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "SubBalance", func(*repository.Repository, types.Address, *big.Int) {
 		return
@@ -232,11 +249,98 @@ func TestStateTransition_TransitionDb3(t *testing.T) {
 	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetCodeHash", func(*repository.Repository, types.Address) types.Hash {
 		return types.Hash{}
 	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetRefund", func(*repository.Repository) uint64 {
+		return 0
+	})
 	ret, used, ok, err, address := state.TransitionDb()
 	assert.Nil(t, err)
 	assert.Equal(t, []byte{'H', 'i'}, ret)
 	assert.Equal(t, ok, false)
-	assert.Equal(t, uint64(0), used)
+	intrinsicGas, _ := filter.IntrinsicGas(tx.Data.Payload, false)
+	assert.Equal(t, intrinsicGas, used)
 	assert.Equal(t, types.Address{}, address)
 	monkey.UnpatchAll()
 }
+
+// test gas limit and balance checks before executing tx
+func TestStateTransition_BuyGas(t *testing.T) {
+	defer monkey.UnpatchAll()
+	bc := &repository.Repository{}
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetNonce", func(*repository.Repository, types.Address) uint64 {
+		return 0
+	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetBalance", func(*repository.Repository, types.Address) *big.Int {
+		return new(big.Int).SetUint64(10000000)
+	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "SubBalance", func(*repository.Repository, types.Address, *big.Int) {
+	})
+
+	// tx gas limit exceeds the gas left in block
+	var gp = common.GasPool(50000)
+	_, _, _, err, _ := NewStateTransition(author, MockBlock.Header, bc, mockTrx(), &gp).TransitionDb()
+	assert.NotNil(t, err)
+	assert.Equal(t, uint64(50000), gp.Gas())
+
+	// sender can not pay for gas
+	gp = common.GasPool(1000000)
+	tx := mockTrx()
+	tx.Data.Price = new(big.Int).SetUint64(1000)
+	_, _, _, err, _ = NewStateTransition(author, MockBlock.Header, bc, tx, &gp).TransitionDb()
+	assert.Equal(t, errInsufficientBalanceForGas, err)
+
+	// tx gas limit is lower than intrinsic gas
+	tx = mockTrx()
+	tx.Data.GasLimit = 21000
+	_, _, _, err, _ = NewStateTransition(author, MockBlock.Header, bc, tx, &gp).TransitionDb()
+	assert.Equal(t, errOutOfGas, err)
+}
+
+// test tx failed in VM consumes gas without invalidating the block
+func TestStateTransition_VMFailed(t *testing.T) {
+	defer monkey.UnpatchAll()
+	bc := &repository.Repository{}
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetNonce", func(*repository.Repository, types.Address) uint64 {
+		return 0
+	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetBalance", func(*repository.Repository, types.Address) *big.Int {
+		return new(big.Int).SetUint64(10000000)
+	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "SubBalance", func(*repository.Repository, types.Address, *big.Int) {
+	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "AddBalance", func(*repository.Repository, types.Address, *big.Int) {
+	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "SetNonce", func(*repository.Repository, types.Address, uint64) {
+	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetRefund", func(*repository.Repository) uint64 {
+		return 0
+	})
+	var evmd *evm.EVM
+	monkey.PatchInstanceMethod(reflect.TypeOf(evmd), "Call", func(*evm.EVM, evm.ContractRef, types.Address, []byte, uint64, *big.Int) ([]byte, uint64, error) {
+		return nil, 0, errors.New("out of gas")
+	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetCode", func(*repository.Repository, types.Address) []byte {
+		return []byte{}
+	})
+	var gp = common.GasPool(1000000)
+	_, used, failed, err, _ := NewStateTransition(author, MockBlock.Header, bc, mockTrx(), &gp).TransitionDb()
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Equal(t, uint64(100000), used)
+
+	// wasm contract
+	code, _ := hex.DecodeString("0061736d0100000001070160027f7f017f03020100070801046961646400000a09010700200020016a0b")
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetCode", func(*repository.Repository, types.Address) []byte {
+		return code
+	})
+	var wvm *wasmExec.VM
+	monkey.PatchInstanceMethod(reflect.TypeOf(wvm), "Call", func(*wasmExec.VM, types.Address, types.Address, []byte, uint64, *big.Int) ([]byte, uint64, error) {
+		return nil, 0, errors.New("out of gas")
+	})
+	tx := mockTrx()
+	tx.Data.Recipient = &wasmContractAddress
+	gp = common.GasPool(1000000)
+	_, used, failed, err, _ = NewStateTransition(author, MockBlock.Header, bc, tx, &gp).TransitionDb()
+	assert.Nil(t, err)
+	assert.True(t, failed)
+	assert.Equal(t, uint64(100000), used)
+}
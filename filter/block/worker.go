@@ -5,12 +5,11 @@ import (
 	"fmt"
 	"github.com/DSiSc/craft/log"
 	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/filter"
 	"github.com/DSiSc/repository"
 	vcommon "github.com/DSiSc/validator/common"
 	"github.com/DSiSc/validator/tools/merkle_tree"
 	"github.com/DSiSc/validator/worker/common"
-	wallett "github.com/DSiSc/wallet/core/types"
-	"math/big"
 )
 
 type Worker struct {
@@ -19,13 +18,32 @@ type Worker struct {
 	receipts  types.Receipts
 	logs      []*types.Log
 	signature bool
+	senders   *filter.SenderCache
+	rules     *filter.ChainRules
+	// txs forbidden by permissions make the block invalid
+	permissions *filter.Permissions
+	// signatures of all txs have been verified by VerifyTrsSignatures
+	signatureVerified bool
+	// the block is verified against parent, nil means the current block of chain
+	parent *types.Block
 }
 
 func NewWorker(chain *repository.Repository, block *types.Block, signVerify bool) *Worker {
+	return NewWorkerWithRules(chain, block, signVerify, filter.DefaultChainRules)
+}
+
+// NewWorkerWithRules create a worker which chooses the tx signer by chain rules, nil rules means
+// filter.DefaultChainRules.
+func NewWorkerWithRules(chain *repository.Repository, block *types.Block, signVerify bool, rules *filter.ChainRules) *Worker {
+	if rules == nil {
+		rules = filter.DefaultChainRules
+	}
 	return &Worker{
 		block:     block,
 		chain:     chain,
 		signature: signVerify,
+		senders:   filter.DefaultSenderCache,
+		rules:     rules,
 	}
 }
 
@@ -39,45 +57,30 @@ func GetTxsRoot(txs []*types.Transaction) types.Hash {
 }
 
 func (self *Worker) VerifyBlock() error {
-	// 1. chainID
-	currentBlock := self.chain.GetCurrentBlock()
-	if self.block.Header.ChainID != currentBlock.Header.ChainID {
-		return fmt.Errorf("wrong Block.Header.ChainID, expected %d, got %d",
-			currentBlock.Header.ChainID, self.block.Header.ChainID)
-	}
-	// 2. hash
-	if self.block.Header.PrevBlockHash != currentBlock.HeaderHash {
-		return fmt.Errorf("wrong Block.Header.PrevBlockHash, expected %x, got %x",
-			currentBlock.HeaderHash, self.block.Header.PrevBlockHash)
-	}
-	// 3. height
-	if self.block.Header.Height != self.chain.GetCurrentBlockHeight()+1 {
-		return fmt.Errorf("wrong Block.Header.Height, expected %x, got %x",
-			self.chain.GetCurrentBlockHeight()+1, self.block.Header.Height)
-	}
-	// 4. txhash
-	txsHash := GetTxsRoot(self.block.Transactions)
-	if self.block.Header.TxRoot != txsHash {
-		return fmt.Errorf("wrong Block.Header.TxRoot, expected %x, got %x",
-			txsHash, self.block.Header.TxRoot)
-	}
-	//5. header hash
-	if !(self.block.HeaderHash == types.Hash{}) {
-		headerHash := vcommon.HeaderHash(self.block)
-		if self.block.HeaderHash != headerHash {
-			return fmt.Errorf("wrong Block.HeaderHash, expected %x, got %x",
-				headerHash, self.block.HeaderHash)
+	// 1-5. verify block header against its parent, the current block is its parent unless specified
+	if self.parent != nil {
+		if err := self.verifyHeader(self.parent, self.parent.Header.Height); err != nil {
+			return err
 		}
+	} else if err := self.verifyHeader(self.chain.GetCurrentBlock(), self.chain.GetCurrentBlockHeight()); err != nil {
+		return err
 	}
 	var (
 		receipts types.Receipts
 		allLogs  []*types.Log
-		gp       = new(common.GasPool).AddGas(uint64(65536))
+		usedGas  = new(uint64)
+		gp       = new(common.GasPool).AddGas(self.block.Header.GasLimit)
 	)
-	// 6. verify every transactions in the block by evm
+	// 6. verify signatures of all transactions concurrently before executing them
+	if self.signature {
+		if err := self.VerifyTrsSignatures(); err != nil {
+			return err
+		}
+	}
+	// 7. verify every transactions in the block by evm
 	for i, tx := range self.block.Transactions {
 		self.chain.Prepare(vcommon.TxHash(tx), self.block.Header.PrevBlockHash, i)
-		receipt, _, err := self.VerifyTransaction(self.block.Header.CoinBase, gp, self.block.Header, tx, new(uint64))
+		receipt, _, err := self.VerifyTransaction(self.block.Header.CoinBase, gp, self.block.Header, tx, usedGas)
 		if err != nil {
 			log.Error("Tx %x verify failed with error %v.", vcommon.TxHash(tx), err)
 			return err
@@ -85,6 +88,12 @@ func (self *Worker) VerifyBlock() error {
 		receipts = append(receipts, receipt)
 		allLogs = append(allLogs, receipt.Logs...)
 	}
+	// verify the gas used by all transactions
+	if *usedGas != self.block.Header.GasUsed {
+		log.Error("Block gas used not consistent which assignment is [%d], while compute is [%d].",
+			self.block.Header.GasUsed, *usedGas)
+		return fmt.Errorf("wrong Block.Header.GasUsed, expected %d, got %d", *usedGas, self.block.Header.GasUsed)
+	}
 	receiptsHash := make([]types.Hash, 0, len(receipts))
 	for _, t := range receipts {
 		receiptsHash = append(receiptsHash, common.ReceiptHash(t))
@@ -102,7 +111,7 @@ func (self *Worker) VerifyBlock() error {
 		log.Debug("Assign receipts hash %x to block %d.", receiptHash, self.block.Header.Height)
 		self.block.Header.ReceiptsRoot = receiptHash
 	}
-	// 7. verify digest if it exists
+	// 8. verify digest if it exists
 	if !(self.block.Header.MixDigest == types.Hash{}) {
 		digestHash := vcommon.HeaderDigest(self.block.Header)
 		if !bytes.Equal(digestHash[:], self.block.Header.MixDigest[:]) {
@@ -111,22 +120,70 @@ func (self *Worker) VerifyBlock() error {
 			return fmt.Errorf("digest not in coincidence")
 		}
 	}
-	// TODO 8. verify state root
+	// 9. verify state root after all transactions are applied
+	if root := self.chain.IntermediateRoot(false); root != self.block.Header.StateRoot {
+		log.Error("Block state root not consistent which assignment is [%x], while compute is [%x].",
+			self.block.Header.StateRoot, root)
+		return &filter.StateRootError{
+			BlockHash: self.block.HeaderHash,
+			Height:    self.block.Header.Height,
+			Expected:  root,
+			Got:       self.block.Header.StateRoot,
+		}
+	}
 	self.receipts = receipts
 	self.logs = allLogs
 
 	return nil
 }
 
+// verify block header against its parent block at parentHeight.
+func (self *Worker) verifyHeader(parent *types.Block, parentHeight uint64) error {
+	// 1. chainID
+	if self.block.Header.ChainID != parent.Header.ChainID {
+		return fmt.Errorf("wrong Block.Header.ChainID, expected %d, got %d",
+			parent.Header.ChainID, self.block.Header.ChainID)
+	}
+	// 2. hash
+	if self.block.Header.PrevBlockHash != parent.HeaderHash {
+		return fmt.Errorf("wrong Block.Header.PrevBlockHash, expected %x, got %x",
+			parent.HeaderHash, self.block.Header.PrevBlockHash)
+	}
+	// 3. height
+	if self.block.Header.Height != parentHeight+1 {
+		return fmt.Errorf("wrong Block.Header.Height, expected %x, got %x",
+			parentHeight+1, self.block.Header.Height)
+	}
+	// 4. txhash
+	txsHash := GetTxsRoot(self.block.Transactions)
+	if self.block.Header.TxRoot != txsHash {
+		return fmt.Errorf("wrong Block.Header.TxRoot, expected %x, got %x",
+			txsHash, self.block.Header.TxRoot)
+	}
+	//5. header hash
+	if !(self.block.HeaderHash == types.Hash{}) {
+		headerHash := vcommon.HeaderHash(self.block)
+		if self.block.HeaderHash != headerHash {
+			return fmt.Errorf("wrong Block.HeaderHash, expected %x, got %x",
+				headerHash, self.block.HeaderHash)
+		}
+	}
+	return nil
+}
+
 func (self *Worker) VerifyTransaction(author types.Address, gp *common.GasPool, header *types.Header,
 	tx *types.Transaction, usedGas *uint64) (*types.Receipt, uint64, error) {
 	// txs signature has been verified by tx switch already, so ignore it here
-	if self.signature {
+	if self.signature && !self.signatureVerified {
 		if self.VerifyTrsSignature(tx) == false {
 			log.Error("Transaction signature verify failed.")
 			return nil, 0, fmt.Errorf("transaction signature failed")
 		}
 	}
+	if err := self.permissions.Check(tx); err != nil {
+		log.Error("Transaction %x is not permitted, as: %v", vcommon.TxHash(tx), err)
+		return nil, 0, err
+	}
 	_, gas, failed, err, contractAddress := ApplyTransaction(author, header, self.chain, tx, gp)
 	if err != nil {
 		log.Error("Apply transaction %x failed with error %v.", vcommon.TxHash(tx), err)
@@ -155,21 +212,40 @@ func (self *Worker) VerifyTransaction(author types.Address, gp *common.GasPool,
 
 func (self *Worker) VerifyTrsSignature(tx *types.Transaction) bool {
 	id := self.block.Header.ChainID
-	chainId := int64(id)
-	signer := wallett.NewEIP155Signer(big.NewInt(chainId))
-	//signer := new(wallett.FrontierSigner)
-	from, err := wallett.Sender(signer, tx)
+	signer := self.rules.Signer(id, self.block.Header.Height)
+	// the sender of tx verified by tx switch has been cached already
+	from, err := self.senders.Sender(id, signer, tx)
 	if nil != err {
 		log.Error("Get from by tx's %x signer failed with %v.", vcommon.TxHash(tx), err)
 		return false
 	}
-	if !bytes.Equal((*(tx.Data.From))[:], from.Bytes()) {
+	if !bytes.Equal((*(tx.Data.From))[:], from[:]) {
 		log.Error("Transaction signature verify failed, tx.Data.From is %x, while signed from is %x.", *tx.Data.From, from)
 		return false
 	}
 	return true
 }
 
+// VerifyTrsSignatures verify the signatures of all transactions in the block, the senders are recovered
+// concurrently across CPU cores.
+func (self *Worker) VerifyTrsSignatures() error {
+	id := self.block.Header.ChainID
+	signer := self.rules.Signer(id, self.block.Header.Height)
+	senders, errs := self.senders.RecoverSenders(id, signer, self.block.Transactions)
+	for i, tx := range self.block.Transactions {
+		if errs[i] != nil {
+			log.Error("Get from by tx's %x signer failed with %v.", vcommon.TxHash(tx), errs[i])
+			return fmt.Errorf("transaction signature failed")
+		}
+		if tx.Data.From == nil || !bytes.Equal((*(tx.Data.From))[:], senders[i][:]) {
+			log.Error("Transaction %x signature verify failed, signed from is %x.", vcommon.TxHash(tx), senders[i])
+			return fmt.Errorf("transaction signature failed")
+		}
+	}
+	self.signatureVerified = true
+	return nil
+}
+
 func (self *Worker) GetReceipts() types.Receipts {
 	log.Debug("Get receipts.")
 	return self.receipts
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/DSiSc/craft/types"
 	"github.com/DSiSc/evm-NG"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/util"
 	"github.com/DSiSc/monkey"
 	"github.com/DSiSc/repository"
 	"github.com/DSiSc/validator/common"
@@ -81,6 +83,7 @@ func TestWorker_VerifyTrsSignature(t *testing.T) {
 	}
 	mockTransaction, _ := wallett.SignTx(mockTrx, wallett.NewEIP155Signer(big.NewInt(1)), key)
 	worker := NewWorker(nil, mockBlock, false)
+	worker.senders = nil
 	ok := worker.VerifyTrsSignature(mockTransaction)
 	assert.Equal(t, true, ok)
 
@@ -98,6 +101,130 @@ func TestWorker_VerifyTrsSignature(t *testing.T) {
 	monkey.UnpatchAll()
 }
 
+func TestWorker_VerifyTrsSignatureCached(t *testing.T) {
+	defer monkey.UnpatchAll()
+	key, _ := wallett.DefaultTestKey()
+	mockBlock := &types.Block{
+		Header: &types.Header{
+			ChainID: uint64(1),
+			Height:  uint64(1),
+		},
+	}
+	mockTrx := &types.Transaction{
+		Data: types.TxData{
+			AccountNonce: uint64(0),
+			Price:        new(big.Int),
+			Recipient:    &addressA,
+			From:         &addressB,
+			Amount:       new(big.Int),
+			Payload:      addressB[:10],
+		},
+	}
+	mockTransaction, _ := wallett.SignTx(mockTrx, wallett.NewEIP155Signer(big.NewInt(1)), key)
+	worker := NewWorker(nil, mockBlock, false)
+	worker.senders = filter.NewSenderCache(16)
+	assert.Equal(t, true, worker.VerifyTrsSignature(mockTransaction))
+
+	// sender is not recovered again once cached
+	monkey.Patch(wallett.Sender, func(wallett.Signer, *types.Transaction) (walletc.Address, error) {
+		return addressC, fmt.Errorf("unknown signer")
+	})
+	assert.Equal(t, true, worker.VerifyTrsSignature(mockTransaction))
+}
+
+func TestWorker_VerifyTrsSignatureByRules(t *testing.T) {
+	defer monkey.UnpatchAll()
+	mockBlock := &types.Block{
+		Header: &types.Header{
+			ChainID: uint64(1),
+			Height:  uint64(5),
+		},
+	}
+	mockTrx := &types.Transaction{
+		Data: types.TxData{
+			AccountNonce: uint64(0),
+			Price:        new(big.Int),
+			Recipient:    &addressA,
+			From:         &addressB,
+			Amount:       new(big.Int),
+		},
+	}
+	var signer wallett.Signer
+	monkey.Patch(wallett.Sender, func(s wallett.Signer, tx *types.Transaction) (walletc.Address, error) {
+		signer = s
+		return walletc.Address(addressB), nil
+	})
+	rules := filter.NewChainRules([]filter.SignerFork{
+		{Height: 0, Scheme: filter.HomesteadScheme},
+		{Height: 10, Scheme: filter.EIP155Scheme},
+	})
+	worker := NewWorkerWithRules(nil, mockBlock, true, rules)
+	worker.senders = nil
+	assert.True(t, worker.VerifyTrsSignature(mockTrx))
+	_, ok := signer.(wallett.HomesteadSigner)
+	assert.True(t, ok, "FAILED: legacy signer is not used before migration height")
+
+	mockBlock.Header.Height = 10
+	assert.True(t, worker.VerifyTrsSignature(mockTrx))
+	_, ok = signer.(wallett.EIP155Signer)
+	assert.True(t, ok, "FAILED: eip155 signer is not used after migration height")
+}
+
+func TestWorker_VerifyTransactionPermission(t *testing.T) {
+	mockTrx := &types.Transaction{
+		Data: types.TxData{
+			Price:  new(big.Int),
+			From:   &addressB,
+			Amount: new(big.Int),
+		},
+	}
+	permissions, err := filter.NewPermissions(&filter.PermissionRules{
+		Deployers: filter.AccessList{Allow: []string{util.Encode(addressA[:])}},
+	})
+	assert.Nil(t, err)
+	worker := NewWorker(nil, &types.Block{Header: &types.Header{}}, false)
+	worker.permissions = permissions
+	_, _, err = worker.VerifyTransaction(addressA, nil, nil, mockTrx, new(uint64))
+	assert.Equal(t, filter.ReasonDeployNotPermitted, filter.ErrorReason(err))
+}
+
+func TestWorker_VerifyTrsSignatures(t *testing.T) {
+	defer monkey.UnpatchAll()
+	key, _ := wallett.DefaultTestKey()
+	mockBlock := &types.Block{
+		Header: &types.Header{
+			ChainID: uint64(1),
+			Height:  uint64(1),
+		},
+	}
+	for i := 0; i < 4; i++ {
+		mockTrx := &types.Transaction{
+			Data: types.TxData{
+				AccountNonce: uint64(i),
+				Price:        new(big.Int),
+				Recipient:    &addressA,
+				From:         &addressB,
+				Amount:       new(big.Int),
+				Payload:      addressB[:10],
+			},
+		}
+		tx, _ := wallett.SignTx(mockTrx, wallett.NewEIP155Signer(big.NewInt(1)), key)
+		mockBlock.Transactions = append(mockBlock.Transactions, tx)
+	}
+	worker := NewWorker(nil, mockBlock, true)
+	worker.senders = nil
+	assert.Nil(t, worker.VerifyTrsSignatures())
+	assert.True(t, worker.signatureVerified)
+
+	worker = NewWorker(nil, mockBlock, true)
+	worker.senders = nil
+	monkey.Patch(wallett.Sender, func(wallett.Signer, *types.Transaction) (walletc.Address, error) {
+		return addressC, nil
+	})
+	assert.NotNil(t, worker.VerifyTrsSignatures())
+	assert.False(t, worker.signatureVerified)
+}
+
 func TestWorker_VerifyBlock(t *testing.T) {
 	assert := assert.New(t)
 	var Repository *repository.Repository
@@ -170,12 +297,81 @@ func TestWorker_VerifyBlock(t *testing.T) {
 	assert.NotNil(err, "Receipts hash not consistent")
 
 	worker.block.Header.ReceiptsRoot = tmp
+	monkey.PatchInstanceMethod(reflect.TypeOf(Repository), "IntermediateRoot", func(*repository.Repository, bool) types.Hash {
+		return MockHash
+	})
+	err = worker.VerifyBlock()
+	assert.Equal(&filter.StateRootError{
+		BlockHash: worker.block.HeaderHash,
+		Height:    1,
+		Expected:  MockHash,
+		Got:       tmp,
+	}, err, "State root not consistent")
+
+	worker.block.Header.StateRoot = MockHash
 	err = worker.VerifyBlock()
 	assert.Nil(err)
 	monkey.UnpatchAll()
 
 }
 
+// mock a block with two txs applied with gas 21000 each
+func mockGasBlock(gasUsed uint64) *Worker {
+	var Repository *repository.Repository
+	txs := []*types.Transaction{mockTrx(), mockTrx()}
+	txs[1].Data.AccountNonce = 1
+	block := &types.Block{
+		Header: &types.Header{
+			ChainID:   uint64(1),
+			Height:    uint64(1),
+			GasLimit:  uint64(1000000),
+			GasUsed:   gasUsed,
+			StateRoot: MockHash,
+			TxRoot:    GetTxsRoot(txs),
+		},
+		Transactions: txs,
+	}
+	monkey.PatchInstanceMethod(reflect.TypeOf(Repository), "GetCurrentBlock", func(*repository.Repository) *types.Block {
+		return &types.Block{Header: &types.Header{ChainID: uint64(1)}}
+	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(Repository), "GetCurrentBlockHeight", func(*repository.Repository) uint64 {
+		return 0
+	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(Repository), "IntermediateRoot", func(*repository.Repository, bool) types.Hash {
+		return MockHash
+	})
+	monkey.Patch(ApplyTransaction, func(author types.Address, header *types.Header, chain *repository.Repository, tx *types.Transaction, gp *workerc.GasPool) ([]byte, uint64, bool, error, types.Address) {
+		return nil, uint64(21000), false, nil, types.Address{}
+	})
+	return NewWorker(nil, block, false)
+}
+
+// Test the gas used by all txs must match the block header
+func TestWorker_VerifyBlockGasUsed(t *testing.T) {
+	defer monkey.UnpatchAll()
+	assert := assert.New(t)
+	worker := mockGasBlock(21000)
+	err := worker.VerifyBlock()
+	assert.NotNil(err, "Block gas used not consistent")
+
+	worker = mockGasBlock(42000)
+	assert.Nil(worker.VerifyBlock())
+}
+
+// Test receipts record the cumulative gas used in block
+func TestWorker_VerifyBlockCumulativeGas(t *testing.T) {
+	defer monkey.UnpatchAll()
+	assert := assert.New(t)
+	worker := mockGasBlock(42000)
+	assert.Nil(worker.VerifyBlock())
+	receipts := worker.GetReceipts()
+	assert.Len(receipts, 2)
+	assert.Equal(uint64(21000), receipts[0].GasUsed)
+	assert.Equal(uint64(21000), receipts[0].CumulativeGasUsed)
+	assert.Equal(uint64(21000), receipts[1].GasUsed)
+	assert.Equal(uint64(42000), receipts[1].CumulativeGasUsed)
+}
+
 func TestWorker_VerifyTransaction(t *testing.T) {
 	assert := assert.New(t)
 	worker := NewWorker(nil, nil, false)
@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/DSiSc/gossipswitch/port"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stage is a named SwitchFilter in Chain.
+type Stage struct {
+	Name   string
+	Filter SwitchFilter
+}
+
+// StageStats is the statistics of a Chain stage.
+type StageStats struct {
+	Name     string
+	Passed   uint64        // number of messages accepted by the stage
+	Rejected uint64        // number of messages rejected by the stage
+	Elapsed  time.Duration // total time spent in the stage
+}
+
+// Chain is a SwitchFilter composed of multiple SwitchFilters. Message is verified by the stages in order,
+// and rejected by the first stage failed to verify it, the following stages will not be called.
+type Chain struct {
+	lock   sync.RWMutex
+	stages []*chainStage
+}
+
+type chainStage struct {
+	passed   uint64 // atomic
+	rejected uint64 // atomic
+	elapsed  int64  // atomic, in nanoseconds
+	Stage
+}
+
+// NewChain create a new filter chain with the stages.
+func NewChain(stages ...Stage) *Chain {
+	chain := &Chain{}
+	for _, stage := range stages {
+		chain.Append(stage)
+	}
+	return chain
+}
+
+// Append add a stage to the end of the chain. Stages can be added while the chain is in use.
+func (chain *Chain) Append(stage Stage) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	stages := make([]*chainStage, 0, len(chain.stages)+1)
+	stages = append(stages, chain.stages...)
+	chain.stages = append(stages, &chainStage{Stage: stage})
+}
+
+// Insert add a stage at the index of the chain, stages at and after the index are moved backward.
+// Return error if the index is out of range.
+func (chain *Chain) Insert(index int, stage Stage) error {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	if index < 0 || index > len(chain.stages) {
+		return fmt.Errorf("index %d out of range [0, %d]", index, len(chain.stages))
+	}
+	// copy on write, as Verify may be iterating the current stages
+	stages := make([]*chainStage, 0, len(chain.stages)+1)
+	stages = append(stages, chain.stages[:index]...)
+	stages = append(stages, &chainStage{Stage: stage})
+	chain.stages = append(stages, chain.stages[index:]...)
+	return nil
+}
+
+// Verify verify the message by all stages in order.
+// return nil if message is accepted by all stages, otherwise return the error of the first failed stage.
+func (chain *Chain) Verify(portId int, msg interface{}) error {
+	return chain.VerifyEnvelope(portId, port.NewEnvelope(msg))
+}
+
+// VerifyEnvelope verify the message in env by all stages in order, the envelope is passed to the stages
+// which are EnvelopeFilters, and the bare message to the others.
+func (chain *Chain) VerifyEnvelope(portId int, env *port.Envelope) error {
+	chain.lock.RLock()
+	stages := chain.stages
+	chain.lock.RUnlock()
+	for _, stage := range stages {
+		start := time.Now()
+		err := VerifyEnvelope(stage.Filter, portId, env)
+		atomic.AddInt64(&stage.elapsed, int64(time.Since(start)))
+		if err != nil {
+			atomic.AddUint64(&stage.rejected, 1)
+			return err
+		}
+		atomic.AddUint64(&stage.passed, 1)
+	}
+	return nil
+}
+
+// Stats return the statistics of all stages in order.
+func (chain *Chain) Stats() []StageStats {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+	stats := make([]StageStats, 0, len(chain.stages))
+	for _, stage := range chain.stages {
+		stats = append(stats, StageStats{
+			Name:     stage.Name,
+			Passed:   atomic.LoadUint64(&stage.passed),
+			Rejected: atomic.LoadUint64(&stage.rejected),
+			Elapsed:  time.Duration(atomic.LoadInt64(&stage.elapsed)),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,157 @@
+package filter
+
+import (
+	"errors"
+	"github.com/DSiSc/craft/types"
+	walletc "github.com/DSiSc/wallet/common"
+	wallett "github.com/DSiSc/wallet/core/types"
+	"math/big"
+	"sort"
+)
+
+// ErrUnprotectedTx is returned by the signer of ReplayProtectedScheme for a legacy unprotected transaction.
+var ErrUnprotectedTx = errors.New("transaction is not replay protected")
+
+// SignerScheme is the scheme used to sign and recover the sender of a transaction.
+type SignerScheme int
+
+const (
+	// EIP155Scheme accept both replay protected and legacy unprotected transactions.
+	EIP155Scheme SignerScheme = iota
+	// HomesteadScheme accept legacy unprotected transactions only, with homestead signature rules.
+	HomesteadScheme
+	// FrontierScheme accept legacy unprotected transactions only, with frontier signature rules.
+	FrontierScheme
+	// ReplayProtectedScheme accept replay protected transactions only, e.g. scheduled at the migration
+	// height to stop accepting legacy transactions.
+	ReplayProtectedScheme
+)
+
+// String return the name of the signer scheme
+func (scheme SignerScheme) String() string {
+	switch scheme {
+	case EIP155Scheme:
+		return "eip155"
+	case HomesteadScheme:
+		return "homestead"
+	case FrontierScheme:
+		return "frontier"
+	case ReplayProtectedScheme:
+		return "replay_protected"
+	default:
+		return "unknown"
+	}
+}
+
+// SignerFork switch the signer scheme from block height Height on.
+type SignerFork struct {
+	Height uint64
+	Scheme SignerScheme
+}
+
+// SignerFunc create the signer of a scheme for chainId.
+type SignerFunc func(chainId uint64) wallett.Signer
+
+// signer constructors of the builtin schemes
+var signerFuncs = map[SignerScheme]SignerFunc{
+	EIP155Scheme: func(chainId uint64) wallett.Signer {
+		return wallett.NewEIP155Signer(new(big.Int).SetUint64(chainId))
+	},
+	HomesteadScheme: func(chainId uint64) wallett.Signer {
+		return wallett.HomesteadSigner{}
+	},
+	FrontierScheme: func(chainId uint64) wallett.Signer {
+		return wallett.FrontierSigner{}
+	},
+	ReplayProtectedScheme: func(chainId uint64) wallett.Signer {
+		return replayProtectedSigner{wallett.NewEIP155Signer(new(big.Int).SetUint64(chainId))}
+	},
+}
+
+// replayProtectedSigner is the EIP155 signer which rejects legacy unprotected transactions.
+type replayProtectedSigner struct {
+	wallett.EIP155Signer
+}
+
+// Sender return ErrUnprotectedTx if tx is not replay protected.
+func (signer replayProtectedSigner) Sender(tx *types.Transaction) (walletc.Address, error) {
+	if !isProtected(tx) {
+		return walletc.Address{}, ErrUnprotectedTx
+	}
+	return signer.EIP155Signer.Sender(tx)
+}
+
+// Equal return true if other is the replay protected signer of the same chain.
+func (signer replayProtectedSigner) Equal(other wallett.Signer) bool {
+	protected, ok := other.(replayProtectedSigner)
+	return ok && signer.EIP155Signer.Equal(protected.EIP155Signer)
+}
+
+// check whether tx is signed with chain id, the V of legacy transaction is 27 or 28.
+func isProtected(tx *types.Transaction) bool {
+	v := tx.Data.V
+	if v == nil {
+		return false
+	}
+	if v.BitLen() <= 8 {
+		return v.Uint64() != 27 && v.Uint64() != 28
+	}
+	return true
+}
+
+// RegisterSignerScheme register the signer constructor of a new scheme, so that it can be used in fork schedule.
+// It is not safe to register scheme while ChainRules are in use.
+func RegisterSignerScheme(scheme SignerScheme, signerFunc SignerFunc) {
+	signerFuncs[scheme] = signerFunc
+}
+
+// DefaultChainRules use EIP155Scheme from the genesis block.
+var DefaultChainRules = NewChainRules(nil)
+
+// ChainRules choose the signer of transactions by block height from a fork schedule, it is shared by
+// transaction and block validation so that both accept the same transactions.
+type ChainRules struct {
+	forks []SignerFork // sorted by height
+}
+
+// NewChainRules create chain rules by fork schedule. The blocks below the lowest fork height use EIP155Scheme,
+// so an empty schedule means EIP155Scheme from the genesis block.
+func NewChainRules(forks []SignerFork) *ChainRules {
+	sorted := make([]SignerFork, len(forks))
+	copy(sorted, forks)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Height < sorted[j].Height
+	})
+	return &ChainRules{forks: sorted}
+}
+
+// Scheme return the signer scheme at block height.
+func (rules *ChainRules) Scheme(height uint64) SignerScheme {
+	scheme := EIP155Scheme
+	for _, fork := range rules.forks {
+		if fork.Height > height {
+			break
+		}
+		scheme = fork.Scheme
+	}
+	return scheme
+}
+
+// Signer return the signer for chainId at block height. The EIP155 signer is returned if the scheme is unknown.
+func (rules *ChainRules) Signer(chainId uint64, height uint64) wallett.Signer {
+	signerFunc, ok := signerFuncs[rules.Scheme(height)]
+	if !ok {
+		signerFunc = signerFuncs[EIP155Scheme]
+	}
+	return signerFunc(chainId)
+}
+
+// HeightDependent return true if the signer changes with block height.
+func (rules *ChainRules) HeightDependent() bool {
+	for _, fork := range rules.forks {
+		if fork.Scheme != EIP155Scheme {
+			return true
+		}
+	}
+	return false
+}
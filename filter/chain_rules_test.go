@@ -0,0 +1,70 @@
+package filter
+
+import (
+	"github.com/DSiSc/craft/types"
+	wallett "github.com/DSiSc/wallet/core/types"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestChainRules_Scheme(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(EIP155Scheme, DefaultChainRules.Scheme(0))
+	assert.False(DefaultChainRules.HeightDependent())
+
+	rules := NewChainRules([]SignerFork{
+		{Height: 100, Scheme: EIP155Scheme},
+		{Height: 0, Scheme: FrontierScheme},
+		{Height: 10, Scheme: HomesteadScheme},
+	})
+	assert.True(rules.HeightDependent())
+	assert.Equal(FrontierScheme, rules.Scheme(0))
+	assert.Equal(FrontierScheme, rules.Scheme(9))
+	assert.Equal(HomesteadScheme, rules.Scheme(10))
+	assert.Equal(HomesteadScheme, rules.Scheme(99))
+	assert.Equal(EIP155Scheme, rules.Scheme(100))
+	assert.Equal(EIP155Scheme, rules.Scheme(1000))
+}
+
+func TestChainRules_Signer(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewChainRules([]SignerFork{
+		{Height: 0, Scheme: HomesteadScheme},
+		{Height: 10, Scheme: EIP155Scheme},
+	})
+	_, ok := rules.Signer(1, 0).(wallett.HomesteadSigner)
+	assert.True(ok, "FAILED: legacy signer is not used before migration height")
+	_, ok = rules.Signer(1, 10).(wallett.EIP155Signer)
+	assert.True(ok, "FAILED: eip155 signer is not used after migration height")
+
+	customScheme := SignerScheme(100)
+	RegisterSignerScheme(customScheme, func(chainId uint64) wallett.Signer {
+		return wallett.FrontierSigner{}
+	})
+	defer delete(signerFuncs, customScheme)
+	_, ok = NewChainRules([]SignerFork{{Height: 0, Scheme: customScheme}}).Signer(1, 0).(wallett.FrontierSigner)
+	assert.True(ok, "FAILED: registered signer scheme is not used")
+}
+
+func TestChainRules_ReplayProtected(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewChainRules([]SignerFork{
+		{Height: 0, Scheme: EIP155Scheme},
+		{Height: 10, Scheme: ReplayProtectedScheme},
+	})
+	assert.True(rules.HeightDependent())
+	legacyTx := &types.Transaction{Data: types.TxData{V: big.NewInt(27)}}
+	protectedTx := &types.Transaction{Data: types.TxData{V: big.NewInt(37)}}
+
+	// legacy tx is accepted before migration height
+	_, err := rules.Signer(1, 9).Sender(legacyTx)
+	assert.NotEqual(ErrUnprotectedTx, err)
+	// and rejected from migration height on
+	_, err = rules.Signer(1, 10).Sender(legacyTx)
+	assert.Equal(ErrUnprotectedTx, err)
+	_, err = rules.Signer(1, 10).Sender(&types.Transaction{})
+	assert.Equal(ErrUnprotectedTx, err)
+	_, err = rules.Signer(1, 10).Sender(protectedTx)
+	assert.NotEqual(ErrUnprotectedTx, err)
+}
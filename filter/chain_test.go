@@ -0,0 +1,93 @@
+package filter
+
+import (
+	"errors"
+	"github.com/DSiSc/gossipswitch/port"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// mock filter records whether it is called
+type mockFilter struct {
+	err    error
+	called int
+}
+
+func (filter *mockFilter) Verify(portId int, msg interface{}) error {
+	filter.called++
+	return filter.err
+}
+
+func TestChain_Verify(t *testing.T) {
+	assert := assert.New(t)
+	first, second := &mockFilter{}, &mockFilter{}
+	chain := NewChain(Stage{Name: "first", Filter: first}, Stage{Name: "second", Filter: second})
+	assert.Nil(chain.Verify(0, struct{}{}))
+	assert.Equal(1, first.called)
+	assert.Equal(1, second.called)
+
+	first.err = errors.New("rejected")
+	assert.Equal(first.err, chain.Verify(0, struct{}{}))
+	assert.Equal(2, first.called)
+	assert.Equal(1, second.called, "FAILED: chain does not short-circuit on failure")
+
+	stats := chain.Stats()
+	assert.Equal(2, len(stats))
+	assert.Equal("first", stats[0].Name)
+	assert.Equal(uint64(1), stats[0].Passed)
+	assert.Equal(uint64(1), stats[0].Rejected)
+	assert.Equal(uint64(1), stats[1].Passed)
+	assert.Equal(uint64(0), stats[1].Rejected)
+}
+
+func TestChain_Insert(t *testing.T) {
+	assert := assert.New(t)
+	chain := NewChain(Stage{Name: "first", Filter: &mockFilter{}})
+	chain.Append(Stage{Name: "third", Filter: &mockFilter{}})
+	assert.Nil(chain.Insert(1, Stage{Name: "second", Filter: &mockFilter{}}))
+	assert.NotNil(chain.Insert(4, Stage{Name: "fifth", Filter: &mockFilter{}}))
+
+	stats := chain.Stats()
+	assert.Equal(3, len(stats))
+	assert.Equal("first", stats[0].Name)
+	assert.Equal("second", stats[1].Name)
+	assert.Equal("third", stats[2].Name)
+}
+
+// mock filter records the messages and envelopes it receives
+type mockEnvelopeFilter struct {
+	msgs []interface{}
+	envs []*port.Envelope
+}
+
+func (filter *mockEnvelopeFilter) Verify(portId int, msg interface{}) error {
+	filter.msgs = append(filter.msgs, msg)
+	return nil
+}
+
+func (filter *mockEnvelopeFilter) VerifyEnvelope(portId int, env *port.Envelope) error {
+	filter.envs = append(filter.envs, env)
+	return nil
+}
+
+// bareFilter hides VerifyEnvelope of mockEnvelopeFilter
+type bareFilter struct {
+	SwitchFilter
+}
+
+func TestChain_VerifyEnvelope(t *testing.T) {
+	assert := assert.New(t)
+	envelopeFilter, plainFilter := &mockEnvelopeFilter{}, &mockEnvelopeFilter{}
+	chain := NewChain(Stage{Name: "envelope", Filter: envelopeFilter}, Stage{Name: "bare", Filter: bareFilter{plainFilter}})
+	msg := &struct{ id int }{1}
+	env := port.NewEnvelope(msg)
+	assert.Nil(VerifyEnvelope(chain, 0, env))
+	assert.Equal([]*port.Envelope{env}, envelopeFilter.envs)
+	assert.Empty(envelopeFilter.msgs)
+	assert.Equal([]interface{}{msg}, plainFilter.msgs, "FAILED: bare message is not passed to SwitchFilter")
+
+	// bare message verified by chain is wrapped for EnvelopeFilter
+	assert.Nil(chain.Verify(0, msg))
+	assert.True(msg == envelopeFilter.envs[1].Msg)
+	assert.Equal([]interface{}{msg, msg}, plainFilter.msgs)
+}
@@ -37,6 +37,21 @@ func TxHash(tx *types.Transaction) (hash types.Hash) {
 	return v
 }
 
+// TxSize calculate the size of rlp encoded tx data, including the signature
+func TxSize(tx *types.Transaction) int {
+	var counter writeCounter
+	rlp.Encode(&counter, &tx.Data)
+	return int(counter)
+}
+
+// writeCounter count the bytes written to it
+type writeCounter int
+
+func (counter *writeCounter) Write(b []byte) (int, error) {
+	*counter += writeCounter(len(b))
+	return len(b), nil
+}
+
 // HeaderHash calculate block's hash
 func HeaderHash(block *types.Block) (hash types.Hash) {
 	//var defaultHash types.Hash
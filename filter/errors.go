@@ -0,0 +1,102 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/DSiSc/craft/types"
+)
+
+// reasons of the message verification failure
+const (
+	ReasonUnsupportedMessage     = "unsupported_message"
+	ReasonInvalidSignature       = "invalid_signature"
+	ReasonNonceTooLow            = "nonce_too_low"
+	ReasonInsufficientFunds      = "insufficient_funds"
+	ReasonIntrinsicGas           = "intrinsic_gas_too_low"
+	ReasonOversizedTx            = "oversized_tx"
+	ReasonOversizedPayload       = "oversized_payload"
+	ReasonGasLimitExceeded       = "gas_limit_exceeded"
+	ReasonUnderpriced            = "underpriced"
+	ReasonContractCreation       = "contract_creation_disabled"
+	ReasonSenderNotPermitted     = "sender_not_permitted"
+	ReasonRecipientNotPermitted  = "recipient_not_permitted"
+	ReasonDeployNotPermitted     = "deploy_not_permitted"
+	ReasonReplacementUnderpriced = "replacement_underpriced"
+	ReasonInvalidWasmCode        = "invalid_wasm_code"
+	ReasonOversizedWasmCode      = "oversized_wasm_code"
+	ReasonDisallowedWasmImport   = "disallowed_wasm_import"
+	ReasonInvalidHeaderHash      = "invalid_header_hash"
+	ReasonUnknownParent          = "unknown_parent"
+	ReasonBlockExisted           = "block_existed"
+	ReasonInvalidBlock           = "invalid_block"
+	ReasonStateRootMismatch      = "state_root_mismatch"
+	ReasonStateUnavailable       = "state_unavailable"  // failed to read the local chain or world state
+	ReasonWriteBlockFailed       = "write_block_failed" // failed to write the verified block to the local chain
+)
+
+// reasons for which a message is always rejected, no matter when it is verified again
+var permanentReasons = map[string]bool{
+	ReasonUnsupportedMessage:   true,
+	ReasonInvalidSignature:     true,
+	ReasonNonceTooLow:          true,
+	ReasonIntrinsicGas:         true,
+	ReasonOversizedTx:          true,
+	ReasonOversizedPayload:     true,
+	ReasonGasLimitExceeded:     true,
+	ReasonUnderpriced:          true,
+	ReasonContractCreation:     true,
+	ReasonInvalidWasmCode:      true,
+	ReasonOversizedWasmCode:    true,
+	ReasonDisallowedWasmImport: true,
+	ReasonInvalidHeaderHash:    true,
+	ReasonBlockExisted:         true,
+	ReasonInvalidBlock:         true,
+	ReasonStateRootMismatch:    true,
+}
+
+// VerifyError is the error returned by SwitchFilter when a message is rejected.
+type VerifyError struct {
+	Reason string // why the message is rejected, e.g. ReasonInvalidSignature
+	Err    error
+}
+
+// NewVerifyError create a new verification error with reason.
+func NewVerifyError(reason string, err error) *VerifyError {
+	return &VerifyError{
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+// Error return the message of the underlying error.
+func (e *VerifyError) Error() string {
+	return e.Err.Error()
+}
+
+// ErrorReason return the reason of the verification error, or empty string if err is not a VerifyError.
+func ErrorReason(err error) string {
+	if verifyErr, ok := err.(*VerifyError); ok {
+		return verifyErr.Reason
+	}
+	return ""
+}
+
+// IsPermanent return true if err is a VerifyError whose reason does not depend on the local state, e.g.
+// an invalid signature. A message rejected for other reasons, e.g. unknown parent, may be accepted later.
+func IsPermanent(err error) bool {
+	return permanentReasons[ErrorReason(err)]
+}
+
+// StateRootError is the error of a block whose header's state root is not the root of the world state
+// after executing the block.
+type StateRootError struct {
+	BlockHash types.Hash
+	Height    uint64
+	Expected  types.Hash // root of the world state after executing the block
+	Got       types.Hash // state root in block header
+}
+
+// Error return the detail of the mismatch.
+func (e *StateRootError) Error() string {
+	return fmt.Sprintf("wrong Block.Header.StateRoot of block %x at height %d, expected %x, got %x",
+		e.BlockHash, e.Height, e.Expected, e.Got)
+}
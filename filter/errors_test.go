@@ -0,0 +1,31 @@
+package filter
+
+import (
+	"errors"
+	"github.com/DSiSc/craft/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerifyError(t *testing.T) {
+	assert := assert.New(t)
+	err := NewVerifyError(ReasonInvalidSignature, errors.New("signature verify failed"))
+	assert.Equal("signature verify failed", err.Error())
+	assert.Equal(ReasonInvalidSignature, ErrorReason(err))
+	assert.Equal("", ErrorReason(errors.New("unknown error")))
+}
+
+func TestIsPermanent(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(IsPermanent(NewVerifyError(ReasonInvalidSignature, errors.New("signature verify failed"))))
+	assert.False(IsPermanent(NewVerifyError(ReasonUnknownParent, errors.New("unknown parent"))))
+	assert.False(IsPermanent(errors.New("unknown error")))
+}
+
+func TestStateRootError(t *testing.T) {
+	assert := assert.New(t)
+	err := &StateRootError{Height: 1, Expected: types.Hash{1}, Got: types.Hash{2}}
+	assert.Contains(err.Error(), "at height 1")
+	assert.Contains(err.Error(), "expected 01")
+	assert.Contains(err.Error(), "got 02")
+}
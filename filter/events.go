@@ -0,0 +1,64 @@
+package filter
+
+import (
+	"github.com/DSiSc/craft/types"
+)
+
+// eventTypeBase is the first event type defined by gossipswitch, event types below it are defined by craft.
+const eventTypeBase types.EventType = 200
+
+// events notified by switch filters through types.EventCenter
+const (
+	// EventTxOversized is notified with *VerifyError when the encoded tx exceeds SwitchConfig.MaxTxSize
+	EventTxOversized types.EventType = eventTypeBase + iota
+	// EventTxPayloadOversized is notified with *VerifyError when tx payload exceeds SwitchConfig.MaxTxPayloadSize
+	EventTxPayloadOversized
+	// EventTxGasLimitExceeded is notified with *VerifyError when tx gas limit exceeds SwitchConfig.MaxTxGasLimit
+	EventTxGasLimitExceeded
+	// EventTxUnderpriced is notified with *VerifyError when tx gas price is lower than SwitchConfig.MinGasPrice
+	EventTxUnderpriced
+	// EventContractCreationDisabled is notified with *VerifyError when a contract creation tx is received
+	// while SwitchConfig.DisableContractCreation is set
+	EventContractCreationDisabled
+	// EventTxNotPermitted is notified with *VerifyError when tx is forbidden by SwitchConfig.Permissions
+	EventTxNotPermitted
+	// EventTxReplaced is notified with *TxReplacement when an accepted tx is replaced by a tx with the same
+	// sender and nonce but higher gas price
+	EventTxReplaced
+	// EventTxConflict is notified with *TxConflict when a tx has the same sender and nonce as an accepted tx
+	EventTxConflict
+	// EventBlockParentRequested is notified with *BlockRequest when a block whose parent is unknown is held
+	// by block switch, the parent should be fetched from peers
+	EventBlockParentRequested
+	// EventChainReorganized is notified with *Reorg when a block on a side branch becomes the head by fork choice
+	EventChainReorganized
+)
+
+// TxReplacement is the payload of EventTxReplaced
+type TxReplacement struct {
+	Old *types.Transaction // the replaced tx
+	New *types.Transaction // the replacement tx
+}
+
+// TxConflict is the payload of EventTxConflict
+type TxConflict struct {
+	Existing        *types.Transaction // the tx accepted before
+	ExistingPort    int                // id of the in port Existing was received from
+	Conflicting     *types.Transaction // the tx with the same sender and nonce as Existing
+	ConflictingPort int                // id of the in port Conflicting was received from
+}
+
+// BlockRequest is the payload of EventBlockParentRequested
+type BlockRequest struct {
+	Hash   types.Hash // hash of the requested block
+	Height uint64     // height of the requested block
+	PortId int        // id of the in port the orphan block was received from
+}
+
+// Reorg is the payload of EventChainReorganized
+type Reorg struct {
+	OldHead *types.Block   // the head before reorganisation
+	NewHead *types.Block   // the head after reorganisation
+	Dropped []*types.Block // blocks leaving the head branch, from OldHead down to the common ancestor
+	Added   []*types.Block // blocks joining the head branch, from the common ancestor up to NewHead
+}
@@ -0,0 +1,27 @@
+package filter
+
+import (
+	"github.com/DSiSc/craft/types"
+)
+
+// ForkChoice choose the head of the local chain among competing branches.
+type ForkChoice interface {
+	// ReplaceHead return true if the branch ending with tip should replace the branch ending with head.
+	ReplaceHead(head, tip *types.Block) bool
+}
+
+// LongestChain is the default fork choice, which prefers the higher tip and keeps the head on tie.
+type LongestChain struct{}
+
+// ReplaceHead return true if tip is higher than head.
+func (LongestChain) ReplaceHead(head, tip *types.Block) bool {
+	return tip.Header.Height > head.Header.Height
+}
+
+// ForkChoiceFunc adapt an ordinary function to ForkChoice.
+type ForkChoiceFunc func(head, tip *types.Block) bool
+
+// ReplaceHead call f(head, tip).
+func (f ForkChoiceFunc) ReplaceHead(head, tip *types.Block) bool {
+	return f(head, tip)
+}
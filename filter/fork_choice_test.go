@@ -0,0 +1,16 @@
+package filter
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Test longest chain fork choice
+func TestLongestChain_ReplaceHead(t *testing.T) {
+	assert := assert.New(t)
+	head := &types.Block{Header: &types.Header{Height: 2}}
+	assert.False(LongestChain{}.ReplaceHead(head, &types.Block{Header: &types.Header{Height: 1}}))
+	assert.False(LongestChain{}.ReplaceHead(head, &types.Block{Header: &types.Header{Height: 2}}))
+	assert.True(LongestChain{}.ReplaceHead(head, &types.Block{Header: &types.Header{Height: 3}}))
+}
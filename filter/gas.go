@@ -0,0 +1,46 @@
+package filter
+
+import (
+	"errors"
+	"math"
+)
+
+// gas cost of transaction
+const (
+	TxGas                 uint64 = 21000 // per transaction not creating a contract
+	TxGasContractCreation uint64 = 53000 // per transaction creating a contract
+	TxDataZeroGas         uint64 = 4     // per zero byte of transaction payload
+	TxDataNonZeroGas      uint64 = 68    // per non-zero byte of transaction payload
+)
+
+// ErrGasUintOverflow is returned when calculating gas usage overflows uint64
+var ErrGasUintOverflow = errors.New("gas uint64 overflow")
+
+// IntrinsicGas computes the intrinsic gas of a transaction with the given payload, which is charged
+// before the transaction is executed.
+func IntrinsicGas(data []byte, contractCreation bool) (uint64, error) {
+	gas := TxGas
+	if contractCreation {
+		gas = TxGasContractCreation
+	}
+	if len(data) == 0 {
+		return gas, nil
+	}
+	var nonZero uint64
+	for _, b := range data {
+		if b != 0 {
+			nonZero++
+		}
+	}
+	if (math.MaxUint64-gas)/TxDataNonZeroGas < nonZero {
+		return 0, ErrGasUintOverflow
+	}
+	gas += nonZero * TxDataNonZeroGas
+
+	zero := uint64(len(data)) - nonZero
+	if (math.MaxUint64-gas)/TxDataZeroGas < zero {
+		return 0, ErrGasUintOverflow
+	}
+	gas += zero * TxDataZeroGas
+	return gas, nil
+}
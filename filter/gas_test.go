@@ -0,0 +1,21 @@
+package filter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIntrinsicGas(t *testing.T) {
+	assert := assert.New(t)
+	gas, err := IntrinsicGas(nil, false)
+	assert.Nil(err)
+	assert.Equal(TxGas, gas)
+
+	gas, err = IntrinsicGas(nil, true)
+	assert.Nil(err)
+	assert.Equal(TxGasContractCreation, gas)
+
+	gas, err = IntrinsicGas([]byte{0, 1, 2}, false)
+	assert.Nil(err)
+	assert.Equal(TxGas+TxDataZeroGas+2*TxDataNonZeroGas, gas)
+}
@@ -0,0 +1,231 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/util"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// AccessList is the allow/deny list of addresses in permission file. An address in Deny is always
+// forbidden, if Allow is not empty, only the addresses in Allow are permitted.
+type AccessList struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// PermissionRules is the content of permission file, e.g.
+//
+//	{
+//	  "senders":    {"allow": ["0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b"], "deny": []},
+//	  "recipients": {"deny": ["0x5f4c7d5d4d7c4e2e6b2f9a7c3c1d1b1a19181716"]},
+//	  "deployers":  {"allow": ["0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b"]}
+//	}
+type PermissionRules struct {
+	Senders    AccessList `json:"senders"`    // who may send tx
+	Recipients AccessList `json:"recipients"` // who may receive tx
+	Deployers  AccessList `json:"deployers"`  // who may deploy contract
+}
+
+// access list parsed from AccessList
+type accessList struct {
+	allow map[types.Address]struct{}
+	deny  map[types.Address]struct{}
+}
+
+func newAccessList(list AccessList) (*accessList, error) {
+	allow, err := addressSet(list.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := addressSet(list.Deny)
+	if err != nil {
+		return nil, err
+	}
+	return &accessList{allow: allow, deny: deny}, nil
+}
+
+func addressSet(addrs []string) (map[types.Address]struct{}, error) {
+	set := make(map[types.Address]struct{}, len(addrs))
+	for _, addr := range addrs {
+		if len(util.FromHex(addr)) != util.AddressLength {
+			return nil, fmt.Errorf("invalid address %s", addr)
+		}
+		set[util.HexToAddress(addr)] = struct{}{}
+	}
+	return set, nil
+}
+
+// check whether addr is permitted by the access list
+func (list *accessList) permitted(addr types.Address) bool {
+	if _, ok := list.deny[addr]; ok {
+		return false
+	}
+	if len(list.allow) == 0 {
+		return true
+	}
+	_, ok := list.allow[addr]
+	return ok
+}
+
+// Permissions restrict who may send tx, receive tx and deploy contract on permissioned chains.
+// Permissions is loaded from a json file of PermissionRules, and can be reloaded while in use.
+// A nil Permissions permits everything.
+type Permissions struct {
+	lock       sync.RWMutex
+	path       string
+	modTime    time.Time
+	senders    *accessList
+	recipients *accessList
+	deployers  *accessList
+	quit       chan struct{}
+	closeOnce  sync.Once
+}
+
+// LoadPermissions load permissions from the permission file.
+func LoadPermissions(path string) (*Permissions, error) {
+	permissions := &Permissions{
+		path: path,
+		quit: make(chan struct{}),
+	}
+	if err := permissions.Reload(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// NewPermissions create permissions by rules, which are not backed by a file.
+func NewPermissions(rules *PermissionRules) (*Permissions, error) {
+	permissions := &Permissions{
+		quit: make(chan struct{}),
+	}
+	if err := permissions.setRules(rules); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// VerifySignatureRequired return whether tx signatures must be verified. Permissions trust tx.Data.From,
+// so signature verification is forced once permissions are enforced, whatever verifySignature is.
+func VerifySignatureRequired(verifySignature bool, permissions *Permissions) bool {
+	return verifySignature || permissions != nil
+}
+
+// Reload reload the permission file. The current permissions are kept if the file is invalid.
+func (permissions *Permissions) Reload() error {
+	info, err := os.Stat(permissions.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat permission file %s, as: %v", permissions.path, err)
+	}
+	data, err := ioutil.ReadFile(permissions.path)
+	if err != nil {
+		return fmt.Errorf("failed to read permission file %s, as: %v", permissions.path, err)
+	}
+	rules := new(PermissionRules)
+	if err := json.Unmarshal(data, rules); err != nil {
+		return fmt.Errorf("failed to parse permission file %s, as: %v", permissions.path, err)
+	}
+	if err := permissions.setRules(rules); err != nil {
+		return fmt.Errorf("invalid permission file %s, as: %v", permissions.path, err)
+	}
+	permissions.lock.Lock()
+	permissions.modTime = info.ModTime()
+	permissions.lock.Unlock()
+	return nil
+}
+
+// replace the current rules
+func (permissions *Permissions) setRules(rules *PermissionRules) error {
+	senders, err := newAccessList(rules.Senders)
+	if err != nil {
+		return err
+	}
+	recipients, err := newAccessList(rules.Recipients)
+	if err != nil {
+		return err
+	}
+	deployers, err := newAccessList(rules.Deployers)
+	if err != nil {
+		return err
+	}
+	permissions.lock.Lock()
+	defer permissions.lock.Unlock()
+	permissions.senders = senders
+	permissions.recipients = recipients
+	permissions.deployers = deployers
+	return nil
+}
+
+// Watch check the permission file every interval, and reload it once modified, until Close is called.
+func (permissions *Permissions) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !permissions.modified() {
+					continue
+				}
+				if err := permissions.Reload(); err != nil {
+					log.Error("Failed to reload permissions, as: %v", err)
+					continue
+				}
+				log.Info("Permission file %s reloaded", permissions.path)
+			case <-permissions.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Close stop watching the permission file.
+func (permissions *Permissions) Close() {
+	permissions.closeOnce.Do(func() {
+		close(permissions.quit)
+	})
+}
+
+// check whether the permission file is modified since last reload
+func (permissions *Permissions) modified() bool {
+	info, err := os.Stat(permissions.path)
+	if err != nil {
+		log.Warn("Failed to stat permission file %s, as: %v", permissions.path, err)
+		return false
+	}
+	permissions.lock.RLock()
+	defer permissions.lock.RUnlock()
+	return !info.ModTime().Equal(permissions.modTime)
+}
+
+// Check check whether tx is permitted, tx.Data.From is trusted as the sender of tx.
+// return nil if tx is permitted, otherwise return a VerifyError.
+func (permissions *Permissions) Check(tx *types.Transaction) error {
+	if permissions == nil {
+		return nil
+	}
+	permissions.lock.RLock()
+	defer permissions.lock.RUnlock()
+	var from types.Address
+	if tx.Data.From != nil {
+		from = *tx.Data.From
+	}
+	if !permissions.senders.permitted(from) {
+		return NewVerifyError(ReasonSenderNotPermitted, fmt.Errorf("sender %x is not permitted", from))
+	}
+	if tx.Data.Recipient == nil {
+		if !permissions.deployers.permitted(from) {
+			return NewVerifyError(ReasonDeployNotPermitted, fmt.Errorf("sender %x is not permitted to deploy contract", from))
+		}
+		return nil
+	}
+	if !permissions.recipients.permitted(*tx.Data.Recipient) {
+		return NewVerifyError(ReasonRecipientNotPermitted, fmt.Errorf("recipient %x is not permitted", *tx.Data.Recipient))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package filter
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/util"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	permittedAddr = util.HexToAddress("0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+	forbiddenAddr = util.HexToAddress("0x5f4c7d5d4d7c4e2e6b2f9a7c3c1d1b1a19181716")
+)
+
+func mockPermissionTx(from types.Address, recipient *types.Address) *types.Transaction {
+	return &types.Transaction{
+		Data: types.TxData{
+			From:      &from,
+			Recipient: recipient,
+		},
+	}
+}
+
+func TestPermissions_Check(t *testing.T) {
+	assert := assert.New(t)
+	permissions, err := NewPermissions(&PermissionRules{
+		Senders:    AccessList{Deny: []string{util.Encode(forbiddenAddr[:])}},
+		Recipients: AccessList{Deny: []string{util.Encode(forbiddenAddr[:])}},
+		Deployers:  AccessList{Allow: []string{util.Encode(permittedAddr[:])}},
+	})
+	assert.Nil(err)
+
+	assert.Nil(permissions.Check(mockPermissionTx(permittedAddr, &permittedAddr)))
+	assert.Nil(permissions.Check(mockPermissionTx(permittedAddr, nil)))
+	assert.Equal(ReasonSenderNotPermitted, ErrorReason(permissions.Check(mockPermissionTx(forbiddenAddr, &permittedAddr))))
+	assert.Equal(ReasonRecipientNotPermitted, ErrorReason(permissions.Check(mockPermissionTx(permittedAddr, &forbiddenAddr))))
+	other := util.HexToAddress("0x0000000000000000000000000000000000000001")
+	assert.Equal(ReasonDeployNotPermitted, ErrorReason(permissions.Check(mockPermissionTx(other, nil))))
+
+	var nilPermissions *Permissions
+	assert.Nil(nilPermissions.Check(mockPermissionTx(forbiddenAddr, nil)))
+
+	_, err = NewPermissions(&PermissionRules{Senders: AccessList{Allow: []string{"0x1234"}}})
+	assert.NotNil(err, "FAILED: invalid address is accepted")
+}
+
+func TestVerifySignatureRequired(t *testing.T) {
+	assert := assert.New(t)
+	permissions, err := NewPermissions(&PermissionRules{})
+	assert.Nil(err)
+	assert.False(VerifySignatureRequired(false, nil))
+	assert.True(VerifySignatureRequired(true, nil))
+	assert.True(VerifySignatureRequired(false, permissions))
+}
+
+func TestPermissions_Reload(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "permissions")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "permissions.json")
+
+	assert.Nil(ioutil.WriteFile(path, []byte(`{"senders": {"deny": ["`+util.Encode(forbiddenAddr[:])+`"]}}`), 0644))
+	permissions, err := LoadPermissions(path)
+	assert.Nil(err)
+	defer permissions.Close()
+	assert.NotNil(permissions.Check(mockPermissionTx(forbiddenAddr, &permittedAddr)))
+	assert.Nil(permissions.Check(mockPermissionTx(permittedAddr, &permittedAddr)))
+
+	// invalid file keeps the current permissions
+	assert.Nil(ioutil.WriteFile(path, []byte(`{"senders": `), 0644))
+	assert.NotNil(permissions.Reload())
+	assert.NotNil(permissions.Check(mockPermissionTx(forbiddenAddr, &permittedAddr)))
+
+	// modified file is reloaded by watcher
+	permissions.Watch(10 * time.Millisecond)
+	assert.Nil(ioutil.WriteFile(path, []byte(`{"senders": {"deny": ["`+util.Encode(permittedAddr[:])+`"]}}`), 0644))
+	os.Chtimes(path, time.Now().Add(time.Second), time.Now().Add(time.Second))
+	deadline := time.Now().Add(2 * time.Second)
+	for permissions.Check(mockPermissionTx(permittedAddr, &permittedAddr)) == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NotNil(permissions.Check(mockPermissionTx(permittedAddr, &permittedAddr)), "FAILED: modified permission file is not reloaded")
+	assert.Nil(permissions.Check(mockPermissionTx(forbiddenAddr, &permittedAddr)))
+
+	_, err = LoadPermissions(filepath.Join(dir, "absent.json"))
+	assert.NotNil(err)
+}
@@ -0,0 +1,140 @@
+package filter
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/util"
+	wallett "github.com/DSiSc/wallet/core/types"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSenderCacheSize is the size of DefaultSenderCache
+const DefaultSenderCacheSize = 65536
+
+// DefaultSenderCache is shared by transaction and block validation, so that the sender of a transaction
+// verified by tx switch is not recovered again when the transaction lands in a block.
+var DefaultSenderCache = NewSenderCache(DefaultSenderCacheSize)
+
+// SenderCache is a bounded LRU cache of the senders recovered from transaction signatures.
+// A nil SenderCache recovers the sender every time.
+type SenderCache struct {
+	lock    sync.Mutex
+	size    int
+	entries map[senderKey]*list.Element
+	order   *list.List // senderEntry, from least to most recently used
+}
+
+// the sender of a transaction depends on the signer scheme and the chain id used by signer
+type senderKey struct {
+	hash    types.Hash
+	chainId uint64
+	signer  string
+}
+
+type senderEntry struct {
+	key  senderKey
+	from types.Address
+}
+
+// NewSenderCache create a new sender cache which holds at most size senders.
+func NewSenderCache(size int) *SenderCache {
+	return &SenderCache{
+		size:    size,
+		entries: make(map[senderKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Sender return the sender of tx signed for chainId by the scheme of signer. The cached sender is returned if there is one,
+// otherwise the sender is recovered by signer and cached.
+func (cache *SenderCache) Sender(chainId uint64, signer wallett.Signer, tx *types.Transaction) (types.Address, error) {
+	if cache == nil {
+		return recoverSender(signer, tx)
+	}
+	key := senderKey{hash: TxHash(tx), chainId: chainId, signer: signerName(signer)}
+	if from, ok := cache.get(key); ok {
+		return from, nil
+	}
+	from, err := recoverSender(signer, tx)
+	if err != nil {
+		return from, err
+	}
+	cache.add(key, from)
+	return from, nil
+}
+
+// RecoverSenders recover the senders of txs concurrently across CPU cores like Sender. The senders and
+// errors are returned in the order of txs.
+func (cache *SenderCache) RecoverSenders(chainId uint64, signer wallett.Signer, txs []*types.Transaction) ([]types.Address, []error) {
+	senders := make([]types.Address, len(txs))
+	errs := make([]error, len(txs))
+	workers := runtime.NumCPU()
+	if workers > len(txs) {
+		workers = len(txs)
+	}
+	var (
+		next int64 = -1
+		wg   sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(atomic.AddInt64(&next, 1)); i < len(txs); i = int(atomic.AddInt64(&next, 1)) {
+				senders[i], errs[i] = cache.Sender(chainId, signer, txs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return senders, errs
+}
+
+// Len return the number of cached senders.
+func (cache *SenderCache) Len() int {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return cache.order.Len()
+}
+
+func (cache *SenderCache) get(key senderKey) (types.Address, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	elem, ok := cache.entries[key]
+	if !ok {
+		return types.Address{}, false
+	}
+	cache.order.MoveToBack(elem)
+	return elem.Value.(*senderEntry).from, true
+}
+
+func (cache *SenderCache) add(key senderKey, from types.Address) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if elem, ok := cache.entries[key]; ok {
+		cache.order.MoveToBack(elem)
+		return
+	}
+	cache.entries[key] = cache.order.PushBack(&senderEntry{key: key, from: from})
+	for cache.order.Len() > cache.size {
+		oldest := cache.order.Front()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*senderEntry).key)
+	}
+}
+
+// name of the signer type, signers of different schemes may recover different senders from the same tx
+func signerName(signer wallett.Signer) string {
+	return fmt.Sprintf("%T", signer)
+}
+
+// recover the sender from tx signature
+func recoverSender(signer wallett.Signer, tx *types.Transaction) (types.Address, error) {
+	from, err := wallett.Sender(signer, tx)
+	if err != nil {
+		return types.Address{}, err
+	}
+	return util.BytesToAddress(from.Bytes()), nil
+}
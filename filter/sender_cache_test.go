@@ -0,0 +1,70 @@
+package filter
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSenderCache_Evict(t *testing.T) {
+	assert := assert.New(t)
+	cache := NewSenderCache(2)
+	first := senderKey{hash: MockHash, chainId: 1}
+	second := senderKey{hash: MockHash, chainId: 2}
+	third := senderKey{hash: MockBlockHash, chainId: 1}
+	cache.add(first, types.Address{1})
+	cache.add(second, types.Address{2})
+
+	// access first, so that second becomes the least recently used one
+	from, ok := cache.get(first)
+	assert.True(ok)
+	assert.Equal(types.Address{1}, from)
+	cache.add(third, types.Address{3})
+	assert.Equal(2, cache.Len())
+	_, ok = cache.get(second)
+	assert.False(ok, "FAILED: least recently used sender is not evicted")
+	_, ok = cache.get(first)
+	assert.True(ok)
+}
+
+func TestSenderCache_Sender(t *testing.T) {
+	assert := assert.New(t)
+	cache := NewSenderCache(2)
+	tx := mockTransaction(0, nil, nil, 0, nil, nil, nil)
+	key := senderKey{hash: TxHash(tx), chainId: 1, signer: signerName(nil)}
+	cache.add(key, types.Address{1})
+
+	from, err := cache.Sender(1, nil, tx)
+	assert.Nil(err)
+	assert.Equal(types.Address{1}, from, "FAILED: cached sender is not used")
+}
+
+func TestSenderCache_RecoverSenders(t *testing.T) {
+	assert := assert.New(t)
+	cache := NewSenderCache(16)
+	txs := make([]*types.Transaction, 10)
+	for i := range txs {
+		txs[i] = mockTransaction(uint64(i), nil, nil, 0, nil, nil, nil)
+		cache.add(senderKey{hash: TxHash(txs[i]), chainId: 1, signer: signerName(nil)}, types.Address{byte(i)})
+	}
+
+	senders, errs := cache.RecoverSenders(1, nil, txs)
+	assert.Equal(len(txs), len(senders))
+	for i := range txs {
+		assert.Nil(errs[i])
+		assert.Equal(types.Address{byte(i)}, senders[i], "FAILED: sender is not returned in order")
+	}
+}
+
+func TestSenderCache_SignerScheme(t *testing.T) {
+	assert := assert.New(t)
+	cache := NewSenderCache(2)
+	tx := mockTransaction(0, nil, nil, 0, nil, nil, nil)
+	eip155 := DefaultChainRules.Signer(1, 0)
+	cache.add(senderKey{hash: TxHash(tx), chainId: 1, signer: signerName(eip155)}, types.Address{1})
+
+	frontier := NewChainRules([]SignerFork{{Height: 0, Scheme: FrontierScheme}}).Signer(1, 0)
+	assert.NotEqual(signerName(eip155), signerName(frontier))
+	_, ok := cache.get(senderKey{hash: TxHash(tx), chainId: 1, signer: signerName(frontier)})
+	assert.False(ok, "FAILED: sender cached by another signer scheme is used")
+}
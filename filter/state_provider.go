@@ -0,0 +1,46 @@
+package filter
+
+import (
+	"github.com/DSiSc/craft/types"
+)
+
+// ChainReader read the blocks of the local chain.
+type ChainReader interface {
+	// CurrentBlock return the head block of the local chain.
+	CurrentBlock() (*types.Block, error)
+	// GetBlockByHash return the block with hash, or error if the block is unknown.
+	GetBlockByHash(hash types.Hash) (*types.Block, error)
+}
+
+// StateProvider provide the world state on which the block switch verifies blocks.
+type StateProvider interface {
+	ChainReader
+	// StateAt return the world state after the block with hash, or error if the block is unknown.
+	StateAt(blockHash types.Hash) (BlockState, error)
+}
+
+// BlockState is the world state after a block, the blocks extending that block are verified and written on it.
+type BlockState interface {
+	// VerifyBlock verify block on the state, return the receipts of block's txs if block is valid.
+	VerifyBlock(block *types.Block, verifyConfig *BlockVerifyConfig) (types.Receipts, error)
+	// WriteBlock write the verified block and its receipts to the local chain.
+	WriteBlock(block *types.Block, receipts types.Receipts) error
+}
+
+// BlockVerifyConfig is the configuration of block verification.
+type BlockVerifyConfig struct {
+	VerifySignature bool
+	Rules           *ChainRules  // choose the signer of txs, nil means DefaultChainRules
+	Permissions     *Permissions // nil means no restriction
+}
+
+// ForkChain is a StateProvider which keeps the blocks of side branches besides the head branch,
+// the block switch handles forks only if its state provider is a ForkChain. A ForkChain used on a real node
+// must verify side branch blocks by executing their txs on the parent state, like the repository provider.
+type ForkChain interface {
+	StateProvider
+	// WriteSideBlock write the verified block of a side branch and its receipts without changing the head.
+	WriteSideBlock(block *types.Block, receipts types.Receipts) error
+	// SetHead make the known block with hash the head of the local chain.
+	SetHead(hash types.Hash) error
+}
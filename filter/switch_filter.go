@@ -1,6 +1,26 @@
 package filter
 
+import "github.com/DSiSc/gossipswitch/port"
+
 // Filter is used to verify SwitchMsg
 type SwitchFilter interface {
+	// Verify verify the message received from in port. msg is the bare message, e.g. *types.Transaction.
 	Verify(portId int, msg interface{}) error
 }
+
+// EnvelopeFilter is a SwitchFilter which wants the metadata of the message, switch calls VerifyEnvelope
+// instead of Verify for it.
+type EnvelopeFilter interface {
+	SwitchFilter
+	// VerifyEnvelope verify the message received from in port, with its metadata in env.
+	VerifyEnvelope(portId int, env *port.Envelope) error
+}
+
+// VerifyEnvelope verify env by switchFilter, the envelope is passed only if switchFilter is an EnvelopeFilter,
+// otherwise the bare message is passed.
+func VerifyEnvelope(switchFilter SwitchFilter, portId int, env *port.Envelope) error {
+	if envelopeFilter, ok := switchFilter.(EnvelopeFilter); ok {
+		return envelopeFilter.VerifyEnvelope(portId, env)
+	}
+	return switchFilter.Verify(portId, env.Msg)
+}
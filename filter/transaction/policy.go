@@ -0,0 +1,58 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/filter"
+	"math/big"
+)
+
+// txPolicy is the admission limits of transaction, zero value means no limit.
+type txPolicy struct {
+	maxTxSize               int
+	maxPayloadSize          int
+	maxGasLimit             uint64
+	minGasPrice             *big.Int
+	disableContractCreation bool
+}
+
+// create tx policy by switch config
+func newTxPolicy(switchConfig *config.SwitchConfig) txPolicy {
+	return txPolicy{
+		maxTxSize:               switchConfig.MaxTxSize,
+		maxPayloadSize:          switchConfig.MaxTxPayloadSize,
+		maxGasLimit:             switchConfig.MaxTxGasLimit,
+		minGasPrice:             switchConfig.MinGasPrice,
+		disableContractCreation: switchConfig.DisableContractCreation,
+	}
+}
+
+// check tx against the policy, return the error and the event to notify if tx is rejected.
+func (policy txPolicy) check(tx *types.Transaction) (types.EventType, error) {
+	if policy.disableContractCreation && tx.Data.Recipient == nil {
+		log.Error("Contract creation is disabled, reject tx %x.", filter.TxHash(tx))
+		return filter.EventContractCreationDisabled, filter.NewVerifyError(filter.ReasonContractCreation, errors.New("contract creation is disabled"))
+	}
+	if policy.maxPayloadSize > 0 && len(tx.Data.Payload) > policy.maxPayloadSize {
+		log.Error("Tx payload size %d exceeds the limit %d.", len(tx.Data.Payload), policy.maxPayloadSize)
+		return filter.EventTxPayloadOversized, filter.NewVerifyError(filter.ReasonOversizedPayload, fmt.Errorf("oversized payload, payload size %d, limit %d", len(tx.Data.Payload), policy.maxPayloadSize))
+	}
+	if policy.maxTxSize > 0 {
+		if size := filter.TxSize(tx); size > policy.maxTxSize {
+			log.Error("Tx size %d exceeds the limit %d.", size, policy.maxTxSize)
+			return filter.EventTxOversized, filter.NewVerifyError(filter.ReasonOversizedTx, fmt.Errorf("oversized tx, tx size %d, limit %d", size, policy.maxTxSize))
+		}
+	}
+	if policy.maxGasLimit > 0 && tx.Data.GasLimit > policy.maxGasLimit {
+		log.Error("Tx gas limit %d exceeds the limit %d.", tx.Data.GasLimit, policy.maxGasLimit)
+		return filter.EventTxGasLimitExceeded, filter.NewVerifyError(filter.ReasonGasLimitExceeded, fmt.Errorf("gas limit exceeded, tx gas limit %d, limit %d", tx.Data.GasLimit, policy.maxGasLimit))
+	}
+	if policy.minGasPrice != nil && (tx.Data.Price == nil || tx.Data.Price.Cmp(policy.minGasPrice) < 0) {
+		log.Error("Tx gas price %v is lower than the min gas price %v.", tx.Data.Price, policy.minGasPrice)
+		return filter.EventTxUnderpriced, filter.NewVerifyError(filter.ReasonUnderpriced, fmt.Errorf("underpriced tx, gas price %v, min gas price %v", tx.Data.Price, policy.minGasPrice))
+	}
+	return 0, nil
+}
@@ -0,0 +1,99 @@
+package transaction
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/port"
+	"github.com/DSiSc/gossipswitch/util"
+	wallett "github.com/DSiSc/wallet/core/types"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func mockPolicyTx(recipient *types.Address, gasLimit uint64, price int64, payload []byte) *types.Transaction {
+	from := addressA1
+	return &types.Transaction{
+		Data: types.TxData{
+			Price:     big.NewInt(price),
+			GasLimit:  gasLimit,
+			Recipient: recipient,
+			From:      &from,
+			Amount:    new(big.Int),
+			Payload:   payload,
+		},
+	}
+}
+
+// Test reject transaction by the admission policy
+func Test_TxFilterVerifyPolicy(t *testing.T) {
+	assert := assert.New(t)
+	center := &eventCenter{}
+	txFilter := NewTxFilterWithConfig(center, &config.SwitchConfig{
+		MaxTxPayloadSize:        8,
+		MaxTxGasLimit:           100000,
+		MinGasPrice:             big.NewInt(10),
+		DisableContractCreation: true,
+	})
+
+	tests := []struct {
+		tx     *types.Transaction
+		reason string
+		event  types.EventType
+	}{
+		{mockPolicyTx(nil, 50000, 10, nil), filter.ReasonContractCreation, filter.EventContractCreationDisabled},
+		{mockPolicyTx(&addressA, 50000, 10, make([]byte, 9)), filter.ReasonOversizedPayload, filter.EventTxPayloadOversized},
+		{mockPolicyTx(&addressA, 100001, 10, nil), filter.ReasonGasLimitExceeded, filter.EventTxGasLimitExceeded},
+		{mockPolicyTx(&addressA, 50000, 9, nil), filter.ReasonUnderpriced, filter.EventTxUnderpriced},
+	}
+	for _, test := range tests {
+		center.events = nil
+		err := txFilter.Verify(port.RemoteInPortId, test.tx)
+		assert.Equal(test.reason, filter.ErrorReason(err))
+		assert.Equal([]types.EventType{test.event, types.EventTxVerifyFailed}, center.events)
+	}
+
+	center.events = nil
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, mockPolicyTx(&addressA, 100000, 10, make([]byte, 8))))
+	assert.Equal([]types.EventType{types.EventTxVerifySucceeded}, center.events)
+}
+
+// Test reject oversized transaction
+func Test_TxFilterVerifyTxSize(t *testing.T) {
+	assert := assert.New(t)
+	tx := mockPolicyTx(&addressA, 50000, 10, make([]byte, 8))
+	size := filter.TxSize(tx)
+	assert.True(size > 0)
+
+	txFilter := NewTxFilterWithConfig(&eventCenter{}, &config.SwitchConfig{MaxTxSize: size})
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, tx))
+	txFilter = NewTxFilterWithConfig(&eventCenter{}, &config.SwitchConfig{MaxTxSize: size - 1})
+	assert.Equal(filter.ReasonOversizedTx, filter.ErrorReason(txFilter.Verify(port.RemoteInPortId, tx)))
+}
+
+// Test reject transaction forbidden by permissions
+func Test_TxFilterVerifyPermissions(t *testing.T) {
+	assert := assert.New(t)
+	key, _ := wallett.DefaultTestKey()
+	sender := util.HexToAddress("0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+	permissions, err := filter.NewPermissions(&filter.PermissionRules{
+		Senders: filter.AccessList{Deny: []string{util.Encode(sender[:])}},
+	})
+	assert.Nil(err)
+	center := &eventCenter{}
+	// sender is recovered from signature even if signature verification is disabled
+	txFilter := NewTxFilterWithConfig(center, &config.SwitchConfig{Permissions: permissions})
+	tx := mockPolicyTx(&addressA, 50000, 10, nil)
+	tx.Data.From = &sender
+	tx, _ = wallett.SignTx(tx, new(wallett.FrontierSigner), key)
+	err = txFilter.Verify(port.RemoteInPortId, tx)
+	assert.Equal(filter.ReasonSenderNotPermitted, filter.ErrorReason(err))
+	assert.Equal([]types.EventType{filter.EventTxNotPermitted, types.EventTxVerifyFailed}, center.events)
+
+	// the denied sender can not pass by forging tx.Data.From
+	forged := mockPolicyTx(&addressA, 50000, 10, nil)
+	forged, _ = wallett.SignTx(forged, new(wallett.FrontierSigner), key)
+	err = txFilter.Verify(port.RemoteInPortId, forged)
+	assert.Equal(filter.ReasonInvalidSignature, filter.ErrorReason(err))
+}
@@ -0,0 +1,66 @@
+package transaction
+
+import (
+	"fmt"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/filter"
+	"math/big"
+)
+
+// DefaultPriceBump is the default min gas price bump in percent for a tx to replace the accepted one
+// with the same sender and nonce.
+const DefaultPriceBump = 10
+
+// verify tx against the tx with the same sender and nonce accepted before. If replacement by fee is enabled,
+// the new tx replaces the accepted one only if its gas price is at least priceBump percent higher, otherwise
+// it is rejected. If conflict detection is enabled, EventTxConflict is notified whenever they are different.
+func (txValidator *TxFilter) verifySlot(portId int, tx *types.Transaction) error {
+	if tx.Data.From == nil {
+		return nil
+	}
+	entry := &txEntry{
+		slot:   txSlot{from: *tx.Data.From, nonce: tx.Data.AccountNonce},
+		hash:   filter.TxHash(tx),
+		tx:     tx,
+		portId: portId,
+	}
+	old, ok := txValidator.index.put(entry, func(old *txEntry) bool {
+		return txValidator.replaceByFee && old.hash != entry.hash && priceBumped(old.tx.Data.Price, tx.Data.Price, txValidator.priceBump)
+	})
+	if old == nil || old.hash == entry.hash {
+		return nil
+	}
+	if txValidator.detectConflict {
+		log.Warn("Tx %x from port %d conflicts with tx %x from port %d, account %x, nonce %d.", entry.hash, portId, old.hash, old.portId, entry.slot.from, entry.slot.nonce)
+		txValidator.eventCenter.Notify(filter.EventTxConflict, &filter.TxConflict{
+			Existing:        old.tx,
+			ExistingPort:    old.portId,
+			Conflicting:     tx,
+			ConflictingPort: portId,
+		})
+	}
+	if !txValidator.replaceByFee {
+		return nil
+	}
+	if !ok {
+		log.Warn("Replacement tx %x of tx %x is underpriced, gas price %v, replaced gas price %v.", entry.hash, old.hash, tx.Data.Price, old.tx.Data.Price)
+		return filter.NewVerifyError(filter.ReasonReplacementUnderpriced, fmt.Errorf("replacement tx underpriced, gas price %v, replaced gas price %v, min bump %d%%", tx.Data.Price, old.tx.Data.Price, txValidator.priceBump))
+	}
+	log.Info("Tx %x of account %x with nonce %d is replaced by tx %x.", old.hash, entry.slot.from, entry.slot.nonce, entry.hash)
+	txValidator.eventCenter.Notify(filter.EventTxReplaced, &filter.TxReplacement{Old: old.tx, New: tx})
+	return nil
+}
+
+// check whether newPrice is at least bump percent higher than oldPrice
+func priceBumped(oldPrice, newPrice *big.Int, bump uint64) bool {
+	if oldPrice == nil {
+		oldPrice = new(big.Int)
+	}
+	if newPrice == nil {
+		newPrice = new(big.Int)
+	}
+	threshold := new(big.Int).Mul(oldPrice, new(big.Int).SetUint64(100+bump))
+	threshold.Div(threshold, big.NewInt(100))
+	return newPrice.Cmp(oldPrice) > 0 && newPrice.Cmp(threshold) >= 0
+}
@@ -0,0 +1,100 @@
+package transaction
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/port"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+// Test replace accepted tx by a tx with higher gas price
+func Test_TxFilterVerifyReplacement(t *testing.T) {
+	assert := assert.New(t)
+	center := &eventCenter{}
+	txFilter := NewTxFilterWithConfig(center, &config.SwitchConfig{ReplaceByFee: true, PriceBump: 10})
+
+	original := mockPolicyTx(&addressA, 50000, 100, nil)
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, original))
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, original), "FAILED: the same tx is rejected")
+
+	// price bump is lower than 10%
+	underpriced := mockPolicyTx(&addressA, 50000, 109, []byte{1})
+	center.events = nil
+	err := txFilter.Verify(port.RemoteInPortId, underpriced)
+	assert.Equal(filter.ReasonReplacementUnderpriced, filter.ErrorReason(err))
+	assert.Equal([]types.EventType{types.EventTxVerifyFailed}, center.events)
+
+	replacement := mockPolicyTx(&addressA, 50000, 110, []byte{2})
+	center.events = nil
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, replacement))
+	assert.Equal([]types.EventType{filter.EventTxReplaced, types.EventTxVerifySucceeded}, center.events)
+
+	// the original tx can not replace its replacement
+	assert.NotNil(txFilter.Verify(port.RemoteInPortId, original))
+	assert.Equal(1, txFilter.index.len())
+
+	// tx with another nonce does not conflict
+	other := mockPolicyTx(&addressA, 50000, 1, nil)
+	other.Data.AccountNonce = 1
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, other))
+	assert.Equal(2, txFilter.index.len())
+}
+
+// Test notify conflict of txs with the same sender and nonce
+func Test_TxFilterVerifyConflict(t *testing.T) {
+	assert := assert.New(t)
+	center := &eventCenter{}
+	txFilter := NewTxFilterWithConfig(center, &config.SwitchConfig{ConflictDetection: true})
+
+	existing := mockPolicyTx(&addressA, 50000, 100, nil)
+	assert.Nil(txFilter.Verify(port.LocalInPortId, existing))
+	conflicting := mockPolicyTx(&addressA, 50000, 1, []byte{1})
+	center.events, center.values = nil, nil
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, conflicting), "FAILED: conflicting tx is rejected without replacement by fee")
+	assert.Equal([]types.EventType{filter.EventTxConflict, types.EventTxVerifySucceeded}, center.events)
+	assert.Equal(&filter.TxConflict{
+		Existing:        existing,
+		ExistingPort:    port.LocalInPortId,
+		Conflicting:     conflicting,
+		ConflictingPort: port.RemoteInPortId,
+	}, center.values[0])
+
+	// the same tx is not a conflict
+	center.events = nil
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, existing))
+	assert.Equal([]types.EventType{types.EventTxVerifySucceeded}, center.events)
+
+	// underpriced replacement is a conflict too
+	txFilter = NewTxFilterWithConfig(center, &config.SwitchConfig{ConflictDetection: true, ReplaceByFee: true})
+	assert.Nil(txFilter.Verify(port.LocalInPortId, existing))
+	center.events = nil
+	assert.NotNil(txFilter.Verify(port.RemoteInPortId, conflicting))
+	assert.Equal([]types.EventType{filter.EventTxConflict, types.EventTxVerifyFailed}, center.events)
+}
+
+// Test check gas price bump
+func Test_priceBumped(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(priceBumped(big.NewInt(100), big.NewInt(110), 10))
+	assert.False(priceBumped(big.NewInt(100), big.NewInt(109), 10))
+	assert.False(priceBumped(big.NewInt(100), big.NewInt(100), 0))
+	assert.True(priceBumped(nil, big.NewInt(1), 10))
+	assert.False(priceBumped(big.NewInt(1), nil, 10))
+}
+
+// Test evict the least recently used slot
+func Test_txIndexEvict(t *testing.T) {
+	assert := assert.New(t)
+	index := newTxIndex(2)
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		_, ok := index.put(&txEntry{slot: txSlot{nonce: nonce}}, nil)
+		assert.True(ok)
+	}
+	assert.Equal(2, index.len())
+	old, ok := index.put(&txEntry{slot: txSlot{nonce: 0}}, nil)
+	assert.Nil(old, "FAILED: least recently used slot is not evicted")
+	assert.True(ok)
+}
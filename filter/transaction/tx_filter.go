@@ -6,6 +6,10 @@ import (
 	"fmt"
 	"github.com/DSiSc/craft/log"
 	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/port"
+	"github.com/DSiSc/repository"
 	"github.com/DSiSc/statedb-NG/util"
 	wallett "github.com/DSiSc/wallet/core/types"
 	"math/big"
@@ -17,43 +21,136 @@ type TxFilter struct {
 	eventCenter     types.EventCenter
 	verifySignature bool
 	chainId         uint64
+	stateCheck      bool
+	senders         *filter.SenderCache
+	rules           *filter.ChainRules
+	policy          txPolicy
+	wasm            *wasmValidator // nil if wasm validation is disabled
+	permissions     *filter.Permissions
+	index           *txIndex // nil if both replacement by fee and conflict detection are disabled
+	replaceByFee    bool
+	priceBump       uint64
+	detectConflict  bool
 }
 
 // create a new transaction filter instance.
 func NewTxFilter(eventCenter types.EventCenter, verifySignature bool, chainId uint64) *TxFilter {
-	return &TxFilter{
+	return NewTxFilterWithConfig(eventCenter, &config.SwitchConfig{
+		VerifySignature: verifySignature,
+		ChainID:         chainId,
+	})
+}
+
+// NewTxFilterWithConfig create a new transaction filter instance by switch config.
+func NewTxFilterWithConfig(eventCenter types.EventCenter, switchConfig *config.SwitchConfig) *TxFilter {
+	txFilter := &TxFilter{
 		eventCenter:     eventCenter,
-		verifySignature: verifySignature,
-		chainId:         chainId,
+		verifySignature: filter.VerifySignatureRequired(switchConfig.VerifySignature, switchConfig.Permissions),
+		chainId:         switchConfig.ChainID,
+		stateCheck:      switchConfig.StatefulTxCheck,
+		senders:         filter.DefaultSenderCache,
+		rules:           filter.NewChainRules(switchConfig.SignerForks),
+		policy:          newTxPolicy(switchConfig),
+		permissions:     switchConfig.Permissions,
+		replaceByFee:    switchConfig.ReplaceByFee,
+		priceBump:       switchConfig.PriceBump,
+		detectConflict:  switchConfig.ConflictDetection,
+	}
+	if txFilter.priceBump == 0 {
+		txFilter.priceBump = DefaultPriceBump
 	}
+	if switchConfig.WasmValidation {
+		txFilter.wasm = newWasmValidator(switchConfig)
+	}
+	if switchConfig.ReplaceByFee || switchConfig.ConflictDetection {
+		txFilter.index = newTxIndex(switchConfig.TxIndexSize)
+	}
+	return txFilter
 }
 
 // Verify verify a switch message whether is validated.
 // return nil if message is validated, otherwise return relative error
 func (txValidator *TxFilter) Verify(portId int, msg interface{}) error {
-	switch msg := msg.(type) {
+	switch msg := port.Unwrap(msg).(type) {
 	case *types.Transaction:
-		return txValidator.doVerify(msg)
+		return txValidator.doVerify(portId, msg)
 	default:
-		return errors.New("unsupported message type")
+		return filter.NewVerifyError(filter.ReasonUnsupportedMessage, errors.New("unsupported message type"))
+	}
+}
+
+// VerifyBatch verify a batch of switch messages. The senders of the transactions are recovered concurrently
+// before the messages are verified one by one. return the verification result of each message in order.
+func (txValidator *TxFilter) VerifyBatch(portId int, msgs []interface{}) []error {
+	if txValidator.verifySignature {
+		txs := make([]*types.Transaction, 0, len(msgs))
+		for _, msg := range msgs {
+			if tx, ok := port.Unwrap(msg).(*types.Transaction); ok {
+				txs = append(txs, tx)
+			}
+		}
+		if signer, err := txValidator.signer(); err == nil {
+			txValidator.senders.RecoverSenders(txValidator.chainId, signer, txs)
+		}
+	}
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		errs[i] = txValidator.Verify(portId, msg)
 	}
+	return errs
 }
 
 // do verify operation
-func (txValidator *TxFilter) doVerify(tx *types.Transaction) error {
+func (txValidator *TxFilter) doVerify(portId int, tx *types.Transaction) error {
+	// check the cheap policy limits first, the policy event is notified before the failure event
+	if event, err := txValidator.policy.check(tx); err != nil {
+		txValidator.eventCenter.Notify(event, err)
+		txValidator.eventCenter.Notify(types.EventTxVerifyFailed, err)
+		return err
+	}
+	if txValidator.wasm != nil {
+		if err := txValidator.wasm.check(tx); err != nil {
+			txValidator.eventCenter.Notify(types.EventTxVerifyFailed, err)
+			return err
+		}
+	}
 	if txValidator.verifySignature {
-		signer := wallett.NewEIP155Signer(big.NewInt(int64(txValidator.chainId)))
-		//signer := new(wallett.FrontierSigner)
-		from, err := wallett.Sender(signer, tx)
+		signer, err := txValidator.signer()
+		if err != nil {
+			log.Error("Failed to get tx signer, as: %v", err)
+			err := filter.NewVerifyError(filter.ReasonStateUnavailable, fmt.Errorf("failed to get tx signer, as: %v", err))
+			txValidator.eventCenter.Notify(types.EventTxVerifyFailed, err)
+			return err
+		}
+		from, err := txValidator.senders.Sender(txValidator.chainId, signer, tx)
 		if nil != err {
 			log.Error("Get from by tx's signer failed with %v.", err)
-			err := fmt.Errorf("Get from by tx's signer failed with %v ", err)
+			err := filter.NewVerifyError(filter.ReasonInvalidSignature, fmt.Errorf("Get from by tx's signer failed with %v ", err))
+			txValidator.eventCenter.Notify(types.EventTxVerifyFailed, err)
+			return err
+		}
+		if !bytes.Equal((*tx.Data.From)[:], from[:]) {
+			log.Error("Transaction signature verify failed. from=%v, tx.data.from=%v, v=%v", util.AddressToHex(from), util.AddressToHex(*(tx.Data.From)), tx.Data.V)
+			err := filter.NewVerifyError(filter.ReasonInvalidSignature, fmt.Errorf("Transaction signature verify failed "))
+			txValidator.eventCenter.Notify(types.EventTxVerifyFailed, err)
+			return err
+		}
+	}
+	// tx.Data.From is trusted only after the signature is verified
+	if err := txValidator.permissions.Check(tx); err != nil {
+		log.Error("Transaction %x is not permitted, as: %v", filter.TxHash(tx), err)
+		txValidator.eventCenter.Notify(filter.EventTxNotPermitted, err)
+		txValidator.eventCenter.Notify(types.EventTxVerifyFailed, err)
+		return err
+	}
+	if txValidator.stateCheck {
+		if err := txValidator.verifyState(tx); err != nil {
 			txValidator.eventCenter.Notify(types.EventTxVerifyFailed, err)
 			return err
 		}
-		if !bytes.Equal((*tx.Data.From)[:], from.Bytes()) {
-			log.Error("Transaction signature verify failed. from=%v, tx.data.from=%v, v=%v", from.String(), util.AddressToHex(*(tx.Data.From)), tx.Data.V)
-			err := fmt.Errorf("Transaction signature verify failed ")
+	}
+	if txValidator.index != nil {
+		if err := txValidator.verifySlot(portId, tx); err != nil {
 			txValidator.eventCenter.Notify(types.EventTxVerifyFailed, err)
 			return err
 		}
@@ -61,3 +158,64 @@ func (txValidator *TxFilter) doVerify(tx *types.Transaction) error {
 	txValidator.eventCenter.Notify(types.EventTxVerifySucceeded, tx)
 	return nil
 }
+
+// get the signer of the next block by chain rules, tx will be packed into the next block at the earliest.
+func (txValidator *TxFilter) signer() (wallett.Signer, error) {
+	var height uint64
+	if txValidator.rules.HeightDependent() {
+		bc, err := getLatestState()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get latest world state, as: %v", err)
+		}
+		height = bc.GetCurrentBlockHeight() + 1
+	}
+	return txValidator.rules.Signer(txValidator.chainId, height), nil
+}
+
+// verify tx's intrinsic gas, nonce and balance against the latest world state
+func (txValidator *TxFilter) verifyState(tx *types.Transaction) error {
+	gas, err := filter.IntrinsicGas(tx.Data.Payload, tx.Data.Recipient == nil)
+	if err != nil || tx.Data.GasLimit < gas {
+		log.Error("Transaction gas limit %d is lower than intrinsic gas %d.", tx.Data.GasLimit, gas)
+		return filter.NewVerifyError(filter.ReasonIntrinsicGas, fmt.Errorf("intrinsic gas too low, gas limit %d, intrinsic gas %d", tx.Data.GasLimit, gas))
+	}
+	if tx.Data.From == nil {
+		return filter.NewVerifyError(filter.ReasonInvalidSignature, errors.New("transaction sender is unknown"))
+	}
+
+	bc, err := getLatestState()
+	if err != nil {
+		log.Error("Failed to get latest world state, as: %v", err)
+		return filter.NewVerifyError(filter.ReasonStateUnavailable, fmt.Errorf("failed to get latest world state, as: %v", err))
+	}
+	from := *tx.Data.From
+	if nonce := bc.GetNonce(from); tx.Data.AccountNonce < nonce {
+		log.Error("Transaction nonce %d is lower than account %x nonce %d.", tx.Data.AccountNonce, from, nonce)
+		return filter.NewVerifyError(filter.ReasonNonceTooLow, fmt.Errorf("nonce too low, tx nonce %d, account nonce %d", tx.Data.AccountNonce, nonce))
+	}
+	cost := txCost(tx)
+	if balance := bc.GetBalance(from); balance.Cmp(cost) < 0 {
+		log.Error("Account %x balance %v is insufficient for tx cost %v.", from, balance, cost)
+		return filter.NewVerifyError(filter.ReasonInsufficientFunds, fmt.Errorf("insufficient funds for gas * price + value, balance %v, cost %v", balance, cost))
+	}
+	return nil
+}
+
+// get the latest world state
+func getLatestState() (*repository.Repository, error) {
+	return repository.NewLatestStateRepository()
+}
+
+// calculate the max cost of tx: GasLimit * Price + Amount
+func txCost(tx *types.Transaction) *big.Int {
+	cost := new(big.Int).SetUint64(tx.Data.GasLimit)
+	if tx.Data.Price != nil {
+		cost.Mul(cost, tx.Data.Price)
+	} else {
+		cost.SetUint64(0)
+	}
+	if tx.Data.Amount != nil {
+		cost.Add(cost, tx.Data.Amount)
+	}
+	return cost
+}
@@ -2,11 +2,17 @@ package transaction
 
 import (
 	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/filter"
 	"github.com/DSiSc/gossipswitch/port"
 	"github.com/DSiSc/gossipswitch/util"
+	"github.com/DSiSc/monkey"
+	"github.com/DSiSc/repository"
 	wallett "github.com/DSiSc/wallet/core/types"
 	"github.com/stretchr/testify/assert"
 	"math/big"
+	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -38,10 +44,12 @@ func Test_TxFilterVerify(t *testing.T) {
 	tx, _ := wallett.SignTx(originalTx, new(wallett.FrontierSigner), key)
 	assert.Nil(txFilter.Verify(port.LocalInPortId, tx), "PASS: verify validated message")
 	assert.Nil(txFilter.Verify(port.RemoteInPortId, tx), "PASS: verify validated message")
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, port.NewEnvelope(tx)), "PASS: verify validated message in envelope")
 
 	block := &types.Block{}
 	assert.NotNil(txFilter.Verify(port.LocalInPortId, block), "PASS: verify in validated message")
 	assert.NotNil(txFilter.Verify(port.RemoteInPortId, block), "PASS: verify in validated message")
+	assert.Equal(filter.ReasonUnsupportedMessage, filter.ErrorReason(txFilter.Verify(port.LocalInPortId, block)))
 }
 
 // Test verify transaction message.
@@ -72,6 +80,81 @@ func Test_TxFilterVerify1(t *testing.T) {
 	assert.NotNil(txFilter.Verify(port.RemoteInPortId, block), "PASS: verify in validated message")
 }
 
+// Test verify a batch of transaction messages.
+func Test_TxFilterVerifyBatch(t *testing.T) {
+	assert := assert.New(t)
+	var txFilter = NewTxFilter(&eventCenter{}, true, 1)
+	assert.NotNil(txFilter, "FAILED: failed to create TxFilter")
+
+	key, _ := wallett.DefaultTestKey()
+	addFrom := util.HexToAddress("0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+	msgs := make([]interface{}, 0, 4)
+	for i := 0; i < 3; i++ {
+		originalTx := &types.Transaction{
+			Data: types.TxData{
+				AccountNonce: uint64(i),
+				Price:        new(big.Int),
+				Recipient:    &addressA,
+				From:         &addFrom,
+				Amount:       new(big.Int),
+				Payload:      addressB[:10],
+			},
+		}
+		tx, _ := wallett.SignTx(originalTx, wallett.NewEIP155Signer(big.NewInt(1)), key)
+		msgs = append(msgs, tx)
+	}
+	msgs = append(msgs, &types.Block{})
+
+	errs := txFilter.VerifyBatch(port.RemoteInPortId, msgs)
+	assert.Equal(len(msgs), len(errs))
+	for i := 0; i < 3; i++ {
+		assert.Nil(errs[i], "PASS: verify validated message")
+	}
+	assert.Equal(filter.ReasonUnsupportedMessage, filter.ErrorReason(errs[3]))
+}
+
+// Test verify transaction against the latest world state.
+func Test_TxFilterVerifyState(t *testing.T) {
+	defer monkey.UnpatchAll()
+	assert := assert.New(t)
+	var txFilter = NewTxFilterWithConfig(&eventCenter{}, &config.SwitchConfig{StatefulTxCheck: true})
+	assert.NotNil(txFilter, "FAILED: failed to create TxFilter")
+
+	var bc *repository.Repository
+	monkey.Patch(getLatestState, func() (*repository.Repository, error) {
+		return bc, nil
+	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetNonce", func(*repository.Repository, types.Address) uint64 {
+		return 1
+	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(bc), "GetBalance", func(*repository.Repository, types.Address) *big.Int {
+		return big.NewInt(21000 + 100)
+	})
+
+	tx := &types.Transaction{
+		Data: types.TxData{
+			AccountNonce: 1,
+			Price:        big.NewInt(1),
+			GasLimit:     filter.TxGas,
+			Recipient:    &addressA,
+			From:         &addressB,
+			Amount:       big.NewInt(100),
+		},
+	}
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, tx), "PASS: verify validated message")
+
+	tx.Data.GasLimit = filter.TxGas - 1
+	assert.Equal(filter.ReasonIntrinsicGas, filter.ErrorReason(txFilter.Verify(port.RemoteInPortId, tx)))
+
+	tx.Data.GasLimit = filter.TxGas
+	tx.Data.AccountNonce = 0
+	assert.Equal(filter.ReasonNonceTooLow, filter.ErrorReason(txFilter.Verify(port.RemoteInPortId, tx)))
+
+	tx.Data.AccountNonce = 2
+	tx.Data.Amount = big.NewInt(101)
+	assert.Equal(filter.ReasonInsufficientFunds, filter.ErrorReason(txFilter.Verify(port.RemoteInPortId, tx)))
+}
+
 var addressA = types.Address{
 	0xb2, 0x6f, 0x2b, 0x34, 0x2a, 0xab, 0x24, 0xbc, 0xf6, 0x3e,
 	0xa2, 0x18, 0xc6, 0xa9, 0x27, 0x4d, 0x30, 0xab, 0x9a, 0x15,
@@ -88,6 +171,9 @@ var addressA1 = types.Address{
 }
 
 type eventCenter struct {
+	lock   sync.Mutex
+	events []types.EventType
+	values []interface{}
 }
 
 // subscriber subscribe specified eventType with eventFunc
@@ -101,7 +187,11 @@ func (*eventCenter) UnSubscribe(eventType types.EventType, subscriber types.Subs
 }
 
 // notify subscriber of eventType
-func (*eventCenter) Notify(eventType types.EventType, value interface{}) (err error) {
+func (center *eventCenter) Notify(eventType types.EventType, value interface{}) (err error) {
+	center.lock.Lock()
+	defer center.lock.Unlock()
+	center.events = append(center.events, eventType)
+	center.values = append(center.values, value)
 	return nil
 }
 
@@ -0,0 +1,75 @@
+package transaction
+
+import (
+	"container/list"
+	"github.com/DSiSc/craft/types"
+	"sync"
+)
+
+// DefaultTxIndexSize is the default number of (sender, nonce) slots remembered by tx filter.
+const DefaultTxIndexSize = 65536
+
+// txSlot is the slot a tx occupies, only one tx of the same sender and nonce can be packed into chain.
+type txSlot struct {
+	from  types.Address
+	nonce uint64
+}
+
+// txEntry is the tx accepted in a slot
+type txEntry struct {
+	slot   txSlot
+	hash   types.Hash
+	tx     *types.Transaction
+	portId int // id of the in port the tx was received from
+}
+
+// txIndex is a bounded LRU index of the txs accepted by tx filter, keyed by slot.
+type txIndex struct {
+	lock    sync.Mutex
+	size    int
+	entries map[txSlot]*list.Element
+	order   *list.List // *txEntry, from least to most recently used
+}
+
+// create a new tx index, DefaultTxIndexSize is used if size is not positive.
+func newTxIndex(size int) *txIndex {
+	if size <= 0 {
+		size = DefaultTxIndexSize
+	}
+	return &txIndex{
+		size:    size,
+		entries: make(map[txSlot]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// put entry into its slot if the slot is empty, or accept returns true for the tx in the slot.
+// return the tx in the slot before put, nil if the slot is empty, and whether entry is put.
+func (index *txIndex) put(entry *txEntry, accept func(old *txEntry) bool) (*txEntry, bool) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	elem, ok := index.entries[entry.slot]
+	if !ok {
+		index.entries[entry.slot] = index.order.PushBack(entry)
+		for index.order.Len() > index.size {
+			oldest := index.order.Front()
+			index.order.Remove(oldest)
+			delete(index.entries, oldest.Value.(*txEntry).slot)
+		}
+		return nil, true
+	}
+	index.order.MoveToBack(elem)
+	old := elem.Value.(*txEntry)
+	if !accept(old) {
+		return old, false
+	}
+	elem.Value = entry
+	return old, true
+}
+
+// len return the number of slots in index
+func (index *txIndex) len() int {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	return index.order.Len()
+}
@@ -0,0 +1,73 @@
+package transaction
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/filter"
+	wasmModule "github.com/DSiSc/wasm/wasm"
+)
+
+// wasmMagic is the magic number at the beginning of wasm binary module
+var wasmMagic = []byte{0x00, 0x61, 0x73, 0x6d}
+
+// wasmValidator check the wasm code deployed by contract creation tx, zero value means no limit.
+type wasmValidator struct {
+	maxCodeSize int
+	disallowed  map[string]struct{} // disallowed import module names or "module.field" names
+}
+
+// create wasm validator by switch config
+func newWasmValidator(switchConfig *config.SwitchConfig) *wasmValidator {
+	disallowed := make(map[string]struct{}, len(switchConfig.DisallowedWasmImports))
+	for _, name := range switchConfig.DisallowedWasmImports {
+		disallowed[name] = struct{}{}
+	}
+	return &wasmValidator{
+		maxCodeSize: switchConfig.MaxWasmCodeSize,
+		disallowed:  disallowed,
+	}
+}
+
+// check the wasm code deployed by tx. Txs which are not contract creation, or deploy contract of other
+// languages, are not checked.
+func (validator *wasmValidator) check(tx *types.Transaction) error {
+	code := tx.Data.Payload
+	if tx.Data.Recipient != nil || !bytes.HasPrefix(code, wasmMagic) {
+		return nil
+	}
+	if validator.maxCodeSize > 0 && len(code) > validator.maxCodeSize {
+		log.Error("Wasm code size %d exceeds the limit %d.", len(code), validator.maxCodeSize)
+		return filter.NewVerifyError(filter.ReasonOversizedWasmCode, fmt.Errorf("oversized wasm code, code size %d, limit %d", len(code), validator.maxCodeSize))
+	}
+	if !wasmModule.IsValidWasmCode(code) {
+		log.Error("Tx %x deploys invalid wasm code.", filter.TxHash(tx))
+		return filter.NewVerifyError(filter.ReasonInvalidWasmCode, fmt.Errorf("invalid wasm code"))
+	}
+	module, err := wasmModule.ReadModule(bytes.NewReader(code), nil)
+	if err != nil {
+		log.Error("Failed to read wasm module of tx %x, as: %v", filter.TxHash(tx), err)
+		return filter.NewVerifyError(filter.ReasonInvalidWasmCode, fmt.Errorf("invalid wasm module, as: %v", err))
+	}
+	if module.Import == nil {
+		return nil
+	}
+	for _, entry := range module.Import.Entries {
+		if validator.isDisallowed(entry.ModuleName, entry.FieldName) {
+			log.Error("Wasm code of tx %x imports disallowed %s.%s.", filter.TxHash(tx), entry.ModuleName, entry.FieldName)
+			return filter.NewVerifyError(filter.ReasonDisallowedWasmImport, fmt.Errorf("disallowed wasm import %s.%s", entry.ModuleName, entry.FieldName))
+		}
+	}
+	return nil
+}
+
+// check whether the import is disallowed by module name or full name
+func (validator *wasmValidator) isDisallowed(moduleName, fieldName string) bool {
+	if _, ok := validator.disallowed[moduleName]; ok {
+		return true
+	}
+	_, ok := validator.disallowed[moduleName+"."+fieldName]
+	return ok
+}
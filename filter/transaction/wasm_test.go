@@ -0,0 +1,54 @@
+package transaction
+
+import (
+	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/port"
+	"github.com/DSiSc/monkey"
+	wasmModule "github.com/DSiSc/wasm/wasm"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+var mockWasmCode = append([]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}, make([]byte, 8)...)
+
+// Test verify the wasm code deployed by contract creation tx
+func Test_TxFilterVerifyWasm(t *testing.T) {
+	defer monkey.UnpatchAll()
+	assert := assert.New(t)
+	txFilter := NewTxFilterWithConfig(&eventCenter{}, &config.SwitchConfig{
+		WasmValidation:        true,
+		MaxWasmCodeSize:       len(mockWasmCode),
+		DisallowedWasmImports: []string{"env.abort", "debug"},
+	})
+
+	// evm code and contract call are not checked
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, mockPolicyTx(nil, 50000, 1, []byte{0x60, 0x80})))
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, mockPolicyTx(&addressA, 50000, 1, append(mockWasmCode, 0))))
+
+	err := txFilter.Verify(port.RemoteInPortId, mockPolicyTx(nil, 50000, 1, append(mockWasmCode, 0)))
+	assert.Equal(filter.ReasonOversizedWasmCode, filter.ErrorReason(err))
+
+	monkey.Patch(wasmModule.IsValidWasmCode, func(code []byte) bool {
+		return false
+	})
+	err = txFilter.Verify(port.RemoteInPortId, mockPolicyTx(nil, 50000, 1, mockWasmCode))
+	assert.Equal(filter.ReasonInvalidWasmCode, filter.ErrorReason(err))
+
+	monkey.Patch(wasmModule.IsValidWasmCode, func(code []byte) bool {
+		return true
+	})
+	var imports []wasmModule.ImportEntry
+	monkey.Patch(wasmModule.ReadModule, func(r io.Reader, resolve wasmModule.ResolveFunc) (*wasmModule.Module, error) {
+		return &wasmModule.Module{Import: &wasmModule.SectionImports{Entries: imports}}, nil
+	})
+	imports = []wasmModule.ImportEntry{{ModuleName: "env", FieldName: "print"}}
+	assert.Nil(txFilter.Verify(port.RemoteInPortId, mockPolicyTx(nil, 50000, 1, mockWasmCode)))
+	imports = []wasmModule.ImportEntry{{ModuleName: "env", FieldName: "abort"}}
+	err = txFilter.Verify(port.RemoteInPortId, mockPolicyTx(nil, 50000, 1, mockWasmCode))
+	assert.Equal(filter.ReasonDisallowedWasmImport, filter.ErrorReason(err))
+	imports = []wasmModule.ImportEntry{{ModuleName: "debug", FieldName: "log"}}
+	err = txFilter.Verify(port.RemoteInPortId, mockPolicyTx(nil, 50000, 1, mockWasmCode))
+	assert.Equal(filter.ReasonDisallowedWasmImport, filter.ErrorReason(err))
+}
@@ -1,6 +1,9 @@
 package gossipswitch
 
-import "github.com/DSiSc/gossipswitch/port"
+import (
+	"context"
+	"github.com/DSiSc/gossipswitch/port"
+)
 
 // GossipSwitchAPI is gossipswitch's public api.
 type GossipSwitchAPI interface {
@@ -10,13 +13,38 @@ type GossipSwitchAPI interface {
 	// InPort get switch's out port by port id, return nil if there is no port with specific id.
 	OutPort(portId int) *port.OutPort
 
+	// AddInPort add a new in port with the specified id to the switch. If the switch is running, the
+	// receive routine of the new port is started immediately. Return error if the port id is in use.
+	AddInPort(portId int) (*port.InPort, error)
+
+	// AddOutPort add a new out port with the specified id to the switch. Return error if the port id is in use.
+	AddOutPort(portId int) (*port.OutPort, error)
+
+	// RemoveInPort remove the in port with the specified id from the switch, and stop its receive routine.
+	RemoveInPort(portId int) error
+
+	// RemoveOutPort remove the out port with the specified id from the switch.
+	RemoveOutPort(portId int) error
+
 	// Start start the switch. Once started, switch will receive message from in port, and broadcast to
-	// out port
+	// out port. A stopped switch can be started again.
 	Start() error
 
-	// Stop stop the switch. Once stopped, switch will stop to receive and broadcast message
+	// Stop stop the switch. Once stopped, switch will stop to receive and broadcast message. Stop returns
+	// after all receive routines and in-flight broadcasts have exited.
 	Stop() error
 
+	// Close stop the switch if it is running, and close all out ports to release their routines.
+	// A closed switch can not be started again.
+	Close() error
+
+	// Submit submit a message to switch through the specified in port, and wait for the verification result.
+	// Return nil if the message is accepted, otherwise return the error of the verification.
+	Submit(ctx context.Context, portId int, msg interface{}) error
+
+	// Stats return the statistics of switch's ports and filters.
+	Stats() *Stats
+
 	// IsRunning is used to query switch's current status. Return true if running, otherwise false
 	IsRunning() bool
 }
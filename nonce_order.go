@@ -0,0 +1,289 @@
+package gossipswitch
+
+import (
+	"container/list"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/port"
+	"github.com/DSiSc/repository"
+	"sort"
+	"sync"
+	"time"
+)
+
+// default limits of the future nonce transactions held by tx switch
+const (
+	DefaultFutureTxQueueSize = 4096
+	DefaultFutureTxTimeout   = time.Minute
+)
+
+// releaseFunc send a verified message to out ports
+type releaseFunc func(inPortId int, msg interface{}) error
+
+// nonceOrderer release the transactions of each sender to out ports in nonce order. A transaction whose
+// nonce is higher than the next nonce of its sender is held until the nonce gap is filled, and is evicted
+// if the gap is not filled within timeout or the queue is full.
+//
+// lock only guards the bookkeeping of the held transactions, the world state lookups and the releases
+// are serialized per sender by senderQueue.lock, so that a slow sender does not stall the others.
+type nonceOrderer struct {
+	lock      sync.Mutex
+	size      int
+	timeout   time.Duration
+	senders   map[types.Address]*senderQueue
+	active    *list.List // *senderQueue, from least to most recently active
+	held      *list.List // *heldTx, from oldest to newest
+	evicted   uint64
+	nonceFunc func(addr types.Address) (uint64, error) // get account nonce from the latest world state
+	onEvict   func(env *port.Envelope)                 // called for each evicted transaction, may be nil
+	replace   bool                                     // whether the tx filter accepts replacements by fee
+}
+
+// the transactions of a sender held by nonceOrderer
+type senderQueue struct {
+	lock  sync.Mutex // serialize the releases of the sender, guard known and next
+	from  types.Address
+	known bool   // whether next has been loaded
+	next  uint64 // next nonce to release
+	// guarded by nonceOrderer.lock
+	txs      map[uint64]*list.Element // nonce -> held tx
+	lastSeen time.Time
+	elem     *list.Element // element in active list
+	removed  bool          // removed from orderer as idle
+}
+
+type heldTx struct {
+	sender *senderQueue
+	nonce  uint64
+	hash   types.Hash
+	portId int
+	env    *port.Envelope
+	added  time.Time
+}
+
+// create a new nonce orderer, default values are used if size or timeout is not positive.
+func newNonceOrderer(size int, timeout time.Duration) *nonceOrderer {
+	if size <= 0 {
+		size = DefaultFutureTxQueueSize
+	}
+	if timeout <= 0 {
+		timeout = DefaultFutureTxTimeout
+	}
+	return &nonceOrderer{
+		size:      size,
+		timeout:   timeout,
+		senders:   make(map[types.Address]*senderQueue),
+		active:    list.New(),
+		held:      list.New(),
+		nonceFunc: getAccountNonce,
+	}
+}
+
+// order release env, and the held transactions it unblocks, by release in nonce order. Messages other than
+// transactions with known sender are released immediately. Release is called with the sender's queue locked,
+// so that the transactions of a sender are never released out of order.
+func (orderer *nonceOrderer) order(portId int, env *port.Envelope, release releaseFunc) error {
+	tx, ok := env.Msg.(*types.Transaction)
+	if !ok || tx.Data.From == nil {
+		return release(portId, env)
+	}
+	queue := orderer.lockSender(*tx.Data.From)
+	defer queue.lock.Unlock()
+	nonce := tx.Data.AccountNonce
+	if !queue.known {
+		// the next nonce of a new sender is its account nonce, or the nonce of its first transaction
+		// if the world state is unavailable.
+		next, err := orderer.nonceFunc(queue.from)
+		if err != nil {
+			log.Warn("Failed to get nonce of account %x, as: %v", queue.from, err)
+			next = nonce
+		}
+		queue.known, queue.next = true, next
+	} else if nonce > queue.next {
+		// the gap may have been filled by a block, refresh the next nonce from the world state
+		if stateNonce, err := orderer.nonceFunc(queue.from); err == nil && stateNonce > queue.next {
+			queue.next = stateNonce
+		}
+	}
+	var err error
+	switch {
+	case nonce < queue.next:
+		// stale or replacement transaction, it does not block anything
+		err = release(portId, env)
+	case nonce == queue.next:
+		queue.next++
+		err = release(portId, env)
+	default:
+		orderer.hold(queue, nonce, portId, env)
+	}
+	for _, held := range orderer.takeReleasable(queue) {
+		if err := release(held.portId, held.env); err != nil {
+			log.Warn("Failed to release transaction of account %x with nonce %d, as: %v", queue.from, held.nonce, err)
+		}
+	}
+	return err
+}
+
+// get the queue of sender and lock it, create the queue if absent.
+func (orderer *nonceOrderer) lockSender(from types.Address) *senderQueue {
+	for {
+		queue := orderer.sender(from)
+		queue.lock.Lock()
+		orderer.lock.Lock()
+		removed := queue.removed
+		orderer.lock.Unlock()
+		if !removed {
+			return queue
+		}
+		// removed as idle before it is locked, use the new queue of sender
+		queue.lock.Unlock()
+	}
+}
+
+// get the queue of sender, create it if absent.
+func (orderer *nonceOrderer) sender(from types.Address) *senderQueue {
+	orderer.lock.Lock()
+	now := time.Now()
+	evicted := orderer.expire(now)
+	queue, ok := orderer.senders[from]
+	if !ok {
+		queue = &senderQueue{
+			from: from,
+			txs:  make(map[uint64]*list.Element),
+		}
+		queue.elem = orderer.active.PushBack(queue)
+		orderer.senders[from] = queue
+	} else {
+		orderer.active.MoveToBack(queue.elem)
+	}
+	queue.lastSeen = now
+	orderer.lock.Unlock()
+	orderer.notifyEvicted(evicted)
+	return queue
+}
+
+// hold a future nonce transaction, the oldest held transaction is evicted if the queue is full.
+// If replacement by fee is enabled, a held transaction is swapped by the different one with the same nonce,
+// which has been accepted by tx filter as its replacement. Otherwise the first one is kept.
+func (orderer *nonceOrderer) hold(queue *senderQueue, nonce uint64, portId int, env *port.Envelope) {
+	hash := filter.TxHash(env.Msg.(*types.Transaction))
+	orderer.lock.Lock()
+	if elem, ok := queue.txs[nonce]; ok {
+		held := elem.Value.(*heldTx)
+		if held.hash == hash || !orderer.replace {
+			log.Debug("Transaction of account %x with nonce %d is held already", queue.from, nonce)
+		} else {
+			log.Debug("Held transaction %x of account %x with nonce %d is replaced by %x", held.hash, queue.from, nonce, hash)
+			held.hash, held.portId, held.env = hash, portId, env
+		}
+		orderer.lock.Unlock()
+		return
+	}
+	queue.txs[nonce] = orderer.held.PushBack(&heldTx{
+		sender: queue,
+		nonce:  nonce,
+		hash:   hash,
+		portId: portId,
+		env:    env,
+		added:  time.Now(),
+	})
+	var evicted []*heldTx
+	for orderer.held.Len() > orderer.size {
+		evicted = append(evicted, orderer.evict(orderer.held.Front()))
+	}
+	orderer.lock.Unlock()
+	orderer.notifyEvicted(evicted)
+}
+
+// take the held transactions of sender which are no longer blocked, in nonce order.
+// Caller must hold the lock of queue.
+func (orderer *nonceOrderer) takeReleasable(queue *senderQueue) []*heldTx {
+	orderer.lock.Lock()
+	defer orderer.lock.Unlock()
+	stale := make([]uint64, 0)
+	for nonce := range queue.txs {
+		if nonce < queue.next {
+			stale = append(stale, nonce)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i] < stale[j] })
+	releasable := make([]*heldTx, 0, len(stale))
+	for _, nonce := range stale {
+		releasable = append(releasable, orderer.remove(queue.txs[nonce]))
+	}
+	for {
+		elem, ok := queue.txs[queue.next]
+		if !ok {
+			return releasable
+		}
+		queue.next++
+		releasable = append(releasable, orderer.remove(elem))
+	}
+}
+
+// evict the held transactions and the idle senders which have timed out, return the evicted transactions.
+// Caller must hold the lock.
+func (orderer *nonceOrderer) expire(now time.Time) []*heldTx {
+	var evicted []*heldTx
+	for elem := orderer.held.Front(); elem != nil; elem = orderer.held.Front() {
+		if now.Sub(elem.Value.(*heldTx).added) < orderer.timeout {
+			break
+		}
+		evicted = append(evicted, orderer.evict(elem))
+	}
+	for elem := orderer.active.Front(); elem != nil; elem = orderer.active.Front() {
+		queue := elem.Value.(*senderQueue)
+		if now.Sub(queue.lastSeen) < orderer.timeout && orderer.active.Len() <= orderer.size {
+			break
+		}
+		for _, txElem := range queue.txs {
+			evicted = append(evicted, orderer.evict(txElem))
+		}
+		orderer.active.Remove(elem)
+		delete(orderer.senders, queue.from)
+		queue.removed = true
+	}
+	return evicted
+}
+
+// caller must hold the lock.
+func (orderer *nonceOrderer) evict(elem *list.Element) *heldTx {
+	held := orderer.remove(elem)
+	orderer.evicted++
+	log.Debug("Evict transaction of account %x with nonce %d", held.sender.from, held.nonce)
+	return held
+}
+
+// caller must hold the lock.
+func (orderer *nonceOrderer) remove(elem *list.Element) *heldTx {
+	held := orderer.held.Remove(elem).(*heldTx)
+	delete(held.sender.txs, held.nonce)
+	return held
+}
+
+// call onEvict for the evicted transactions, caller must not hold the lock.
+func (orderer *nonceOrderer) notifyEvicted(evicted []*heldTx) {
+	if orderer.onEvict == nil {
+		return
+	}
+	for _, held := range evicted {
+		orderer.onEvict(held.env)
+	}
+}
+
+// counts return the number of held transactions and the number of evicted transactions.
+func (orderer *nonceOrderer) counts() (held int, evicted uint64) {
+	orderer.lock.Lock()
+	defer orderer.lock.Unlock()
+	return orderer.held.Len(), orderer.evicted
+}
+
+// get account nonce from the latest world state
+func getAccountNonce(addr types.Address) (uint64, error) {
+	bc, err := repository.NewLatestStateRepository()
+	if err != nil {
+		return 0, err
+	}
+	return bc.GetNonce(addr), nil
+}
@@ -0,0 +1,333 @@
+package gossipswitch
+
+import (
+	"errors"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/port"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+var mockSender = types.Address{0xa9, 0x4f, 0x53, 0x74}
+
+// create an envelope of tx sent by mockSender
+func mockNonceTx(nonce uint64) *port.Envelope {
+	return mockSenderNonceTx(mockSender, nonce)
+}
+
+// create an envelope of tx sent by from
+func mockSenderNonceTx(from types.Address, nonce uint64) *port.Envelope {
+	return port.NewEnvelope(&types.Transaction{
+		Data: types.TxData{
+			AccountNonce: nonce,
+			From:         &from,
+		},
+	})
+}
+
+// create an envelope of tx sent by mockSender with gas price
+func mockPricedNonceTx(nonce uint64, price int64) *port.Envelope {
+	env := mockNonceTx(nonce)
+	env.Msg.(*types.Transaction).Data.Price = big.NewInt(price)
+	return env
+}
+
+// create a nonce orderer whose account nonce is always nonce
+func mockNonceOrderer(size int, timeout time.Duration, nonce uint64) *nonceOrderer {
+	orderer := newNonceOrderer(size, timeout)
+	orderer.nonceFunc = func(addr types.Address) (uint64, error) {
+		return nonce, nil
+	}
+	return orderer
+}
+
+// record the nonces of the released transactions
+type releasedNonces []uint64
+
+func (released *releasedNonces) release(inPortId int, msg interface{}) error {
+	*released = append(*released, msg.(*port.Envelope).Msg.(*types.Transaction).Data.AccountNonce)
+	return nil
+}
+
+// Test release transactions in nonce order
+func Test_NonceOrdererOrder(t *testing.T) {
+	assert := assert.New(t)
+	orderer := mockNonceOrderer(16, time.Minute, 0)
+	var released releasedNonces
+	assert.Nil(orderer.order(port.RemoteInPortId, mockNonceTx(2), released.release))
+	assert.Nil(orderer.order(port.RemoteInPortId, mockNonceTx(1), released.release))
+	assert.Empty(released, "FAILED: future nonce tx is released")
+	held, _ := orderer.counts()
+	assert.Equal(2, held)
+
+	assert.Nil(orderer.order(port.RemoteInPortId, mockNonceTx(0), released.release))
+	assert.Equal(releasedNonces{0, 1, 2}, released)
+	held, _ = orderer.counts()
+	assert.Equal(0, held)
+
+	// stale tx is released immediately
+	assert.Nil(orderer.order(port.RemoteInPortId, mockNonceTx(1), released.release))
+	assert.Equal(releasedNonces{0, 1, 2, 1}, released)
+
+	// message which is not a tx is released immediately
+	msgs := 0
+	orderer.order(port.RemoteInPortId, port.NewEnvelope(&types.Block{}), func(inPortId int, msg interface{}) error {
+		msgs++
+		return nil
+	})
+	assert.Equal(1, msgs)
+}
+
+// Test the held tx is swapped by its replacement
+func Test_NonceOrdererReplace(t *testing.T) {
+	assert := assert.New(t)
+	orderer := mockNonceOrderer(16, time.Minute, 0)
+	orderer.replace = true
+	var prices []int64
+	release := func(inPortId int, msg interface{}) error {
+		prices = append(prices, msg.(*port.Envelope).Msg.(*types.Transaction).Data.Price.Int64())
+		return nil
+	}
+	orderer.order(port.RemoteInPortId, mockPricedNonceTx(1, 10), release)
+	orderer.order(port.RemoteInPortId, mockPricedNonceTx(1, 20), release)
+	held, _ := orderer.counts()
+	assert.Equal(1, held)
+	orderer.order(port.RemoteInPortId, mockPricedNonceTx(0, 10), release)
+	assert.Equal([]int64{10, 20}, prices, "FAILED: replacement tx is not released")
+}
+
+// Test the first held tx is kept if replacement by fee is disabled
+func Test_NonceOrdererKeepFirst(t *testing.T) {
+	assert := assert.New(t)
+	orderer := mockNonceOrderer(16, time.Minute, 0)
+	var prices []int64
+	release := func(inPortId int, msg interface{}) error {
+		prices = append(prices, msg.(*port.Envelope).Msg.(*types.Transaction).Data.Price.Int64())
+		return nil
+	}
+	orderer.order(port.RemoteInPortId, mockPricedNonceTx(1, 20), release)
+	orderer.order(port.RemoteInPortId, mockPricedNonceTx(1, 10), release)
+	orderer.order(port.RemoteInPortId, mockPricedNonceTx(0, 10), release)
+	assert.Equal([]int64{10, 20}, prices, "FAILED: held tx is replaced by the last arrival")
+}
+
+// Test the gap filled by a block is detected from world state
+func Test_NonceOrdererRefresh(t *testing.T) {
+	assert := assert.New(t)
+	stateNonce := uint64(0)
+	orderer := newNonceOrderer(16, time.Minute)
+	orderer.nonceFunc = func(addr types.Address) (uint64, error) {
+		return stateNonce, nil
+	}
+	var released releasedNonces
+	orderer.order(port.RemoteInPortId, mockNonceTx(2), released.release)
+	orderer.order(port.RemoteInPortId, mockNonceTx(4), released.release)
+	assert.Empty(released)
+
+	stateNonce = 3
+	orderer.order(port.RemoteInPortId, mockNonceTx(5), released.release)
+	assert.Equal(releasedNonces{2}, released)
+	orderer.order(port.RemoteInPortId, mockNonceTx(3), released.release)
+	assert.Equal(releasedNonces{2, 3, 4, 5}, released)
+}
+
+// Test evict future nonce tx when the queue is full or timed out
+func Test_NonceOrdererEvict(t *testing.T) {
+	assert := assert.New(t)
+	orderer := mockNonceOrderer(2, time.Minute, 0)
+	var released releasedNonces
+	orderer.order(port.RemoteInPortId, mockNonceTx(1), released.release)
+	orderer.order(port.RemoteInPortId, mockNonceTx(2), released.release)
+	orderer.order(port.RemoteInPortId, mockNonceTx(3), released.release)
+	held, evicted := orderer.counts()
+	assert.Equal(2, held)
+	assert.Equal(uint64(1), evicted)
+	orderer.order(port.RemoteInPortId, mockNonceTx(0), released.release)
+	assert.Equal(releasedNonces{0}, released, "FAILED: evicted tx is released")
+
+	orderer = mockNonceOrderer(16, 50*time.Millisecond, 0)
+	released = nil
+	orderer.order(port.RemoteInPortId, mockNonceTx(1), released.release)
+	time.Sleep(100 * time.Millisecond)
+	orderer.order(port.RemoteInPortId, mockNonceTx(0), released.release)
+	assert.Equal(releasedNonces{0}, released, "FAILED: timed out tx is released")
+	_, evicted = orderer.counts()
+	assert.Equal(uint64(1), evicted)
+}
+
+// Test a sender waiting for world state does not block the other senders
+func Test_NonceOrdererPerSender(t *testing.T) {
+	assert := assert.New(t)
+	slowSender := types.Address{0x01}
+	blocked := make(chan struct{})
+	orderer := newNonceOrderer(16, time.Minute)
+	orderer.nonceFunc = func(addr types.Address) (uint64, error) {
+		if addr == slowSender {
+			<-blocked
+		}
+		return 0, nil
+	}
+	defer close(blocked)
+	go orderer.order(port.RemoteInPortId, mockSenderNonceTx(slowSender, 0), func(int, interface{}) error { return nil })
+
+	released := make(chan struct{})
+	go func() {
+		orderer.order(port.RemoteInPortId, mockNonceTx(0), func(int, interface{}) error { return nil })
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("FAILED: tx is blocked by the world state lookup of another sender")
+	}
+	held, _ := orderer.counts()
+	assert.Equal(0, held)
+}
+
+// Test the first tx of sender is used as the next nonce if world state is unavailable
+func Test_NonceOrdererNoState(t *testing.T) {
+	assert := assert.New(t)
+	orderer := newNonceOrderer(16, time.Minute)
+	orderer.nonceFunc = func(addr types.Address) (uint64, error) {
+		return 0, errors.New("no state")
+	}
+	var released releasedNonces
+	orderer.order(port.RemoteInPortId, mockNonceTx(5), released.release)
+	orderer.order(port.RemoteInPortId, mockNonceTx(7), released.release)
+	orderer.order(port.RemoteInPortId, mockNonceTx(6), released.release)
+	assert.Equal(releasedNonces{5, 6, 7}, released)
+}
+
+// Test tx switch forwards transactions in nonce order
+func Test_onRecvMsgNonceOrdering(t *testing.T) {
+	assert := assert.New(t)
+	switchConfig := mockSwitchConfig()
+	switchConfig.VerifySignature = false
+	switchConfig.NonceOrdering = true
+	sw, err := NewGossipSwitchByType(TxSwitch, &eventCenter{}, switchConfig)
+	assert.Nil(err)
+	sw.orderer.nonceFunc = func(addr types.Address) (uint64, error) {
+		return 0, nil
+	}
+	recvMsgChan := make(chan interface{}, 2)
+	sw.OutPort(port.LocalOutPortId).BindToPort(func(msg interface{}) error {
+		recvMsgChan <- msg
+		return nil
+	})
+
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, mockNonceTx(1)))
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, mockNonceTx(0)))
+	assert.Equal(uint64(0), (<-recvMsgChan).(*types.Transaction).Data.AccountNonce)
+	assert.Equal(uint64(1), (<-recvMsgChan).(*types.Transaction).Data.AccountNonce)
+	assert.Equal(&FutureTxStats{Held: 0, Evicted: 0}, sw.Stats().FutureTxs)
+}
+
+// Test tx switch forwards the replacement of a held transaction
+func Test_onRecvMsgNonceOrderingReplaceByFee(t *testing.T) {
+	assert := assert.New(t)
+	switchConfig := mockSwitchConfig()
+	switchConfig.VerifySignature = false
+	switchConfig.NonceOrdering = true
+	switchConfig.ReplaceByFee = true
+	sw, err := NewGossipSwitchByType(TxSwitch, &eventCenter{}, switchConfig)
+	assert.Nil(err)
+	sw.orderer.nonceFunc = func(addr types.Address) (uint64, error) {
+		return 0, nil
+	}
+	recvMsgChan := make(chan interface{}, 3)
+	sw.OutPort(port.LocalOutPortId).BindToPort(func(msg interface{}) error {
+		recvMsgChan <- msg
+		return nil
+	})
+
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, mockPricedNonceTx(1, 10)))
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, mockPricedNonceTx(1, 20)))
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, mockPricedNonceTx(0, 10)))
+	assert.Equal(uint64(0), (<-recvMsgChan).(*types.Transaction).Data.AccountNonce)
+	replacement := (<-recvMsgChan).(*types.Transaction)
+	assert.Equal(uint64(1), replacement.Data.AccountNonce)
+	assert.Equal(int64(20), replacement.Data.Price.Int64())
+	select {
+	case msg := <-recvMsgChan:
+		t.Errorf("FAILED: unexpected tx %v is forwarded", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(&FutureTxStats{Held: 0, Evicted: 0}, sw.Stats().FutureTxs)
+}
+
+// Test the evicted tx is accepted again when it is received next time
+func Test_onRecvMsgEvictedTx(t *testing.T) {
+	assert := assert.New(t)
+	switchConfig := mockSwitchConfig()
+	switchConfig.VerifySignature = false
+	switchConfig.NonceOrdering = true
+	switchConfig.FutureTxQueueSize = 1
+	switchConfig.SeenCacheSize = 16
+	sw, err := NewGossipSwitchByType(TxSwitch, &eventCenter{}, switchConfig)
+	assert.Nil(err)
+	sw.orderer.nonceFunc = func(addr types.Address) (uint64, error) {
+		return 0, nil
+	}
+
+	tx := mockNonceTx(1).Msg
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, tx))
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, mockNonceTx(2)))
+	assert.Equal(&FutureTxStats{Held: 1, Evicted: 1}, sw.Stats().FutureTxs)
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, tx), "FAILED: evicted tx is taken as duplicate")
+	assert.Equal(ErrDuplicateMessage, sw.onRecvMsg(port.RemoteInPortId, tx))
+}
+
+// Test reading statistics while transactions are released does not deadlock
+func Test_StatsWhileOrdering(t *testing.T) {
+	assert := assert.New(t)
+	switchConfig := mockSwitchConfig()
+	switchConfig.VerifySignature = false
+	switchConfig.NonceOrdering = true
+	switchConfig.OutPortQueueSize = 1
+	sw, err := NewGossipSwitchByType(TxSwitch, &eventCenter{}, switchConfig)
+	assert.Nil(err)
+	sw.orderer.nonceFunc = func(addr types.Address) (uint64, error) {
+		return 0, nil
+	}
+	// a slow subscriber keeps the releases blocked on the full out port queue
+	unblock := make(chan struct{})
+	sw.OutPort(port.LocalOutPortId).BindToPort(func(msg interface{}) error {
+		<-unblock
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(sender byte) {
+			defer wg.Done()
+			from := types.Address{sender}
+			for nonce := uint64(20); ; nonce-- {
+				sw.onRecvMsg(port.RemoteInPortId, mockSenderNonceTx(from, nonce))
+				if nonce == 0 {
+					return
+				}
+			}
+		}(byte(i))
+	}
+	time.Sleep(50 * time.Millisecond)
+	statsDone := make(chan struct{})
+	go func() {
+		defer close(statsDone)
+		for i := 0; i < 100; i++ {
+			sw.Stats()
+			sw.DuplicateCount()
+		}
+	}()
+	select {
+	case <-statsDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("FAILED: statistics are blocked by the releases of transactions")
+	}
+	close(unblock)
+	wg.Wait()
+	assert.Equal(&FutureTxStats{Held: 0, Evicted: 0}, sw.Stats().FutureTxs)
+}
@@ -0,0 +1,131 @@
+package gossipswitch
+
+import (
+	"container/list"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/port"
+	"sync"
+	"time"
+)
+
+// DefaultOrphanTTL is the default time an orphan block is held.
+const DefaultOrphanTTL = 10 * time.Minute
+
+// orphanPool is a bounded pool of the blocks whose parent is unknown, keyed by their parent hash.
+// When the pool is full or a block is held longer than ttl, the oldest block is evicted.
+type orphanPool struct {
+	lock     sync.Mutex
+	size     int
+	ttl      time.Duration
+	orphans  map[types.Hash]*list.Element   // block hash -> orphan
+	children map[types.Hash][]*list.Element // parent hash -> orphans
+	order    *list.List                     // *orphan, from oldest to newest
+	evicted  uint64
+}
+
+type orphan struct {
+	hash   types.Hash
+	parent types.Hash
+	portId int
+	env    *port.Envelope
+	added  time.Time
+}
+
+// create a new orphan pool, DefaultOrphanTTL is used if ttl is not positive.
+func newOrphanPool(size int, ttl time.Duration) *orphanPool {
+	if ttl <= 0 {
+		ttl = DefaultOrphanTTL
+	}
+	return &orphanPool{
+		size:     size,
+		ttl:      ttl,
+		orphans:  make(map[types.Hash]*list.Element),
+		children: make(map[types.Hash][]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// add the block in env to pool. Return true if its parent is not waited for by any other orphan,
+// so the parent should be requested.
+func (pool *orphanPool) add(portId int, env *port.Envelope) bool {
+	block, ok := env.Msg.(*types.Block)
+	if !ok || block.Header == nil {
+		return false
+	}
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	now := time.Now()
+	pool.expire(now)
+	hash := filter.HeaderHash(block)
+	if _, ok := pool.orphans[hash]; ok {
+		return false
+	}
+	parent := block.Header.PrevBlockHash
+	elem := pool.order.PushBack(&orphan{
+		hash:   hash,
+		parent: parent,
+		portId: portId,
+		env:    env,
+		added:  now,
+	})
+	pool.orphans[hash] = elem
+	pool.children[parent] = append(pool.children[parent], elem)
+	for pool.order.Len() > pool.size {
+		pool.remove(pool.order.Front())
+		pool.evicted++
+	}
+	_, held := pool.orphans[hash]
+	return held && len(pool.children[parent]) == 1
+}
+
+// take the orphans whose parent is the block with hash out of pool, in the order they were added.
+func (pool *orphanPool) take(parent types.Hash) []*orphan {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	pool.expire(time.Now())
+	elems := pool.children[parent]
+	orphans := make([]*orphan, 0, len(elems))
+	for _, elem := range elems {
+		orphan := pool.order.Remove(elem).(*orphan)
+		delete(pool.orphans, orphan.hash)
+		orphans = append(orphans, orphan)
+	}
+	delete(pool.children, parent)
+	return orphans
+}
+
+// evict the orphans which have been held longer than ttl
+func (pool *orphanPool) expire(now time.Time) {
+	for elem := pool.order.Front(); elem != nil; elem = pool.order.Front() {
+		if now.Sub(elem.Value.(*orphan).added) < pool.ttl {
+			return
+		}
+		pool.remove(elem)
+		pool.evicted++
+	}
+}
+
+func (pool *orphanPool) remove(elem *list.Element) {
+	orphan := pool.order.Remove(elem).(*orphan)
+	delete(pool.orphans, orphan.hash)
+	siblings := pool.children[orphan.parent]
+	for i, sibling := range siblings {
+		if sibling == elem {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(pool.children, orphan.parent)
+	} else {
+		pool.children[orphan.parent] = siblings
+	}
+}
+
+// counts return the number of held orphans and the number of evicted orphans.
+func (pool *orphanPool) counts() (held int, evicted uint64) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return pool.order.Len(), pool.evicted
+}
@@ -0,0 +1,119 @@
+package gossipswitch
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/filter/block"
+	"github.com/DSiSc/gossipswitch/port"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// mock a block extending parent
+func mockChildBlock(parent *types.Block) *types.Block {
+	child := &types.Block{
+		Header: &types.Header{
+			ChainID:       parent.Header.ChainID,
+			PrevBlockHash: parent.HeaderHash,
+			TxRoot:        block.GetTxsRoot(nil),
+			Height:        parent.Header.Height + 1,
+		},
+	}
+	child.HeaderHash = filter.HeaderHash(child)
+	return child
+}
+
+// mock a genesis block
+func mockGenesisBlock() *types.Block {
+	genesis := &types.Block{
+		Header: &types.Header{ChainID: 1},
+	}
+	genesis.HeaderHash = filter.HeaderHash(genesis)
+	return genesis
+}
+
+// Test hold and take orphan blocks
+func Test_OrphanPoolTake(t *testing.T) {
+	assert := assert.New(t)
+	pool := newOrphanPool(4, time.Minute)
+	genesis := mockGenesisBlock()
+	block1 := mockChildBlock(genesis)
+	block2a := mockChildBlock(block1)
+	block2b := mockChildBlock(block1)
+	block2b.Header.Timestamp = 1
+	block2b.HeaderHash = filter.HeaderHash(block2b)
+
+	assert.True(pool.add(port.RemoteInPortId, port.NewEnvelope(block2a)), "FAILED: parent is not requested")
+	assert.False(pool.add(port.RemoteInPortId, port.NewEnvelope(block2b)), "FAILED: parent is requested twice")
+	assert.False(pool.add(port.RemoteInPortId, port.NewEnvelope(block2a)))
+	assert.False(pool.add(port.RemoteInPortId, port.NewEnvelope(&types.Transaction{})))
+	held, _ := pool.counts()
+	assert.Equal(2, held)
+
+	assert.Empty(pool.take(genesis.HeaderHash))
+	orphans := pool.take(block1.HeaderHash)
+	assert.Equal(2, len(orphans))
+	assert.Equal(block2a, orphans[0].env.Msg)
+	assert.Equal(block2b, orphans[1].env.Msg)
+	held, _ = pool.counts()
+	assert.Equal(0, held)
+}
+
+// Test evict orphan blocks when the pool is full or timed out
+func Test_OrphanPoolEvict(t *testing.T) {
+	assert := assert.New(t)
+	pool := newOrphanPool(1, time.Minute)
+	genesis := mockGenesisBlock()
+	block1 := mockChildBlock(genesis)
+	block2 := mockChildBlock(block1)
+	pool.add(port.RemoteInPortId, port.NewEnvelope(block1))
+	pool.add(port.RemoteInPortId, port.NewEnvelope(block2))
+	assert.Empty(pool.take(genesis.HeaderHash), "FAILED: oldest orphan is not evicted")
+	held, evicted := pool.counts()
+	assert.Equal(1, held)
+	assert.Equal(uint64(1), evicted)
+
+	pool = newOrphanPool(4, 50*time.Millisecond)
+	pool.add(port.RemoteInPortId, port.NewEnvelope(block1))
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(pool.take(genesis.HeaderHash), "FAILED: orphan is not expired")
+}
+
+// Test block switch verifies orphan block again once its parent is accepted
+func Test_onRecvOrphanBlock(t *testing.T) {
+	assert := assert.New(t)
+	genesis := mockGenesisBlock()
+	center := &eventCenter{}
+	sw, err := NewGossipSwitchByType(BlockSwitch, center, &config.SwitchConfig{
+		StateProvider:  block.NewMemoryStateProvider(genesis),
+		OrphanPoolSize: 16,
+		SeenCacheSize:  16,
+	})
+	assert.Nil(err)
+	recvMsgChan := make(chan interface{}, 3)
+	sw.OutPort(port.LocalOutPortId).BindToPort(func(msg interface{}) error {
+		recvMsgChan <- msg
+		return nil
+	})
+
+	block1 := mockChildBlock(genesis)
+	block2 := mockChildBlock(block1)
+	block3 := mockChildBlock(block2)
+	err = sw.onRecvMsg(port.RemoteInPortId, block3)
+	assert.Equal(filter.ReasonUnknownParent, filter.ErrorReason(err))
+	err = sw.onRecvMsg(port.RemoteInPortId, block2)
+	assert.Equal(filter.ReasonUnknownParent, filter.ErrorReason(err))
+	assert.Equal([]interface{}{
+		&filter.BlockRequest{Hash: block2.HeaderHash, Height: 2, PortId: port.RemoteInPortId},
+		&filter.BlockRequest{Hash: block1.HeaderHash, Height: 1, PortId: port.RemoteInPortId},
+	}, center.notified(filter.EventBlockParentRequested))
+	assert.Equal(&OrphanStats{Held: 2}, sw.Stats().Orphans)
+
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, block1))
+	assert.Equal(block1, <-recvMsgChan)
+	assert.Equal(block2, <-recvMsgChan)
+	assert.Equal(block3, <-recvMsgChan)
+	assert.Equal(&OrphanStats{Held: 0}, sw.Stats().Orphans)
+}
@@ -0,0 +1,61 @@
+package port
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Envelope carries a message through the switch, along with the metadata of its origin and propagation.
+// Bare messages sent to InPort are wrapped into Envelope by switch.
+type Envelope struct {
+	Msg      interface{} // the carried message, e.g. *types.Transaction
+	Origin   string      // id of the peer the message came from, empty if unknown or local
+	InPort   int         // id of the in port the message is received from
+	Received time.Time   // when the message is received by switch
+	Hops     uint32      // number of switches the message has passed through
+	TTL      uint32      // max number of hops, 0 means unlimited
+	TraceID  string      // id used to trace the message across nodes
+}
+
+// NewEnvelope wrap msg into a new Envelope. If msg is an Envelope already, a copy of it is returned.
+// A trace id is generated if the envelope has none.
+func NewEnvelope(msg interface{}) *Envelope {
+	var env Envelope
+	if e, ok := msg.(*Envelope); ok {
+		env = *e
+	} else {
+		env = Envelope{Msg: msg}
+	}
+	if env.TraceID == "" {
+		env.TraceID = newTraceID()
+	}
+	return &env
+}
+
+// Unwrap return the message carried by msg if msg is an Envelope, otherwise return msg itself.
+func Unwrap(msg interface{}) interface{} {
+	if env, ok := msg.(*Envelope); ok {
+		return env.Msg
+	}
+	return msg
+}
+
+// Expired return true if the message has reached its max hops.
+func (env *Envelope) Expired() bool {
+	return env.TTL > 0 && env.Hops >= env.TTL
+}
+
+// Forward return a copy of the envelope with hop count increased, used when the message leaves the switch.
+func (env *Envelope) Forward() *Envelope {
+	forwarded := *env
+	forwarded.Hops++
+	return &forwarded
+}
+
+// generate a random trace id
+func newTraceID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,43 @@
+package port
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Test wrap message into Envelope
+func Test_NewEnvelope(t *testing.T) {
+	assert := assert.New(t)
+	txMsg := &types.Transaction{}
+	env := NewEnvelope(txMsg)
+	assert.True(txMsg == env.Msg)
+	assert.NotEqual("", env.TraceID)
+
+	env.Origin = "peer"
+	copied := NewEnvelope(env)
+	assert.False(env == copied, "FAILED: envelope is not copied")
+	assert.Equal(env.TraceID, copied.TraceID)
+	assert.Equal("peer", copied.Origin)
+}
+
+// Test unwrap message from Envelope
+func Test_Unwrap(t *testing.T) {
+	assert := assert.New(t)
+	txMsg := &types.Transaction{}
+	assert.True(txMsg == Unwrap(NewEnvelope(txMsg)))
+	assert.True(txMsg == Unwrap(txMsg))
+}
+
+// Test forward Envelope
+func TestEnvelope_Forward(t *testing.T) {
+	assert := assert.New(t)
+	env := NewEnvelope(&types.Transaction{})
+	env.TTL = 1
+	assert.False(env.Expired())
+
+	forwarded := env.Forward()
+	assert.Equal(uint32(0), env.Hops)
+	assert.Equal(uint32(1), forwarded.Hops)
+	assert.True(forwarded.Expired())
+}
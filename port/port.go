@@ -1,28 +1,51 @@
 package port
 
 import (
+	"context"
+	"errors"
 	"github.com/DSiSc/craft/log"
 	"sync"
+	"sync/atomic"
 )
 
 // common const value
 const (
-	LocalInPortId   = 0 //Local InPort ID, receive the message from local
-	RemoteInPortId  = 1 //Remote InPort ID, receive the message from remote
-	LocalOutPortId  = 0 //Local OutPort ID
-	RemoteOutPortId = 1 //Remote OutPort ID
+	LocalInPortId    = 0    //Local InPort ID, receive the message from local
+	RemoteInPortId   = 1    //Remote InPort ID, receive the message from remote
+	LocalOutPortId   = 0    //Local OutPort ID
+	RemoteOutPortId  = 1    //Remote OutPort ID
+	DefaultQueueSize = 1024 //Default OutPort queue size
 )
 
-// state is used to record switch port state. e.g., message statistics
+// OverflowPolicy specify what OutPort does when a message is written to its full queue.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock block the writer until there is room in the queue.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drop the oldest queued message to make room for the new one.
+	OverflowDropOldest
+	// OverflowDropNewest drop the message being written.
+	OverflowDropNewest
+)
+
+// state is used to record OutPort state. e.g., message statistics
 type state struct {
-	InCount  uint
-	OutCount uint
+	InCount  uint64 // atomic, number of messages written to the port
+	OutCount uint64 // atomic, number of messages delivered by the port
+}
+
+// Stats is the message statistics of OutPort.
+type Stats struct {
+	Written   uint64 // number of messages written to the port
+	Delivered uint64 // number of deliveries to the bound functions, a message bound to n functions counts n
+	Dropped   uint64 // number of messages dropped from intake queue and deliveries dropped from OutPutFunc queues because of overflow
 }
 
-// InPort is switch in port. Message will be send to InPort, and then switch Read the message from InPort
+// InPort is switch in port. Message will be send to InPort, and then switch Read the message from InPort.
+// Both bare message and Envelope can be sent to InPort. The messages received from InPort are counted by switch.
 type InPort struct {
 	id      int
-	state   state
 	channel chan interface{}
 }
 
@@ -30,7 +53,6 @@ type InPort struct {
 func NewInPort(id int) *InPort {
 	return &InPort{
 		id:      id,
-		state:   state{},
 		channel: make(chan interface{}),
 	}
 }
@@ -50,31 +72,82 @@ func (inPort *InPort) Read() <-chan interface{} {
 	return inPort.channel
 }
 
-// OutPutFunc is binded to switch out port, and OutPort will call OutPutFunc when receive a message from switch
+// OutPutFunc is binded to switch out port, and OutPort will call OutPutFunc when receive a message from switch.
+// OutPutFunc always receives the bare message, use EnvelopeFunc to get the message metadata.
 type OutPutFunc func(msg interface{}) error
 
-// InPort is switch out port. Switch will broadcast message to out port
+// EnvelopeFunc is binded to switch out port like OutPutFunc, but receives the message wrapped in Envelope.
+type EnvelopeFunc func(env *Envelope) error
+
+// OutPort is switch out port. Switch will broadcast message to out port. The messages written to OutPort
+// are sequenced by a single intake queue, and fanned out by a dispatch routine to the delivery queues of the
+// bound OutPutFuncs, so that every OutPutFunc sees the messages in the same order. Each OutPutFunc has its
+// own delivery routine, so that a slow OutPutFunc will not stall the others until its queue is full.
 type OutPort struct {
+	state       state  // keep 64-bit atomic fields first for alignment
+	dropped     uint64 // atomic
 	id          int
 	outPortMtx  sync.Mutex
-	state       state
-	outPutFuncs []OutPutFunc
+	subscribers []*subscriber
+	queueSize   int
+	policy      OverflowPolicy
+	intake      chan interface{} // messages written to the port, in the order they are accepted
+	quit        chan struct{}
+	closeOnce   sync.Once
+}
+
+// subscriber is an OutPutFunc or EnvelopeFunc bound to OutPort, with the queue of the messages to be delivered to it.
+type subscriber struct {
+	outPutFunc   OutPutFunc
+	envelopeFunc EnvelopeFunc
+	queue        chan interface{}
 }
 
-// create a new out port instance
+// create a new out port instance with default queue size and OverflowBlock policy
 func NewOutPort(id int) *OutPort {
-	return &OutPort{
-		id:    id,
-		state: state{},
+	return NewOutPortWithQueue(id, DefaultQueueSize, OverflowBlock)
+}
+
+// NewOutPortWithQueue create a new out port instance with the specified intake and per OutPutFunc queue size
+// and overflow policy, and start its dispatch routine. DefaultQueueSize is used if queueSize is not positive.
+func NewOutPortWithQueue(id int, queueSize int, policy OverflowPolicy) *OutPort {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	outPort := &OutPort{
+		id:        id,
+		state:     state{},
+		queueSize: queueSize,
+		policy:    policy,
+		intake:    make(chan interface{}, queueSize),
+		quit:      make(chan struct{}),
 	}
+	go outPort.dispatchRoutine()
+	return outPort
 }
 
-// BindToPort bind a new OutPutFunc to this OutPort. Return error if bind failed
+// BindToPort bind a new OutPutFunc to this OutPort, and start its delivery routine. Return error if bind failed
 func (outPort *OutPort) BindToPort(outPutFunc OutPutFunc) error {
 	log.Info("Bind OutPutFunc to OutPort")
+	return outPort.subscribe(&subscriber{outPutFunc: outPutFunc})
+}
+
+// BindEnvelopeToPort bind a new EnvelopeFunc to this OutPort, and start its delivery routine. Return error if bind failed
+func (outPort *OutPort) BindEnvelopeToPort(envelopeFunc EnvelopeFunc) error {
+	log.Info("Bind EnvelopeFunc to OutPort")
+	return outPort.subscribe(&subscriber{envelopeFunc: envelopeFunc})
+}
+
+// add subscriber to this OutPort, and start its delivery routine.
+func (outPort *OutPort) subscribe(sub *subscriber) error {
 	outPort.outPortMtx.Lock()
 	defer outPort.outPortMtx.Unlock()
-	outPort.outPutFuncs = append(outPort.outPutFuncs, outPutFunc)
+	if outPort.isClosed() {
+		return errors.New("out port has been closed")
+	}
+	sub.queue = make(chan interface{}, outPort.queueSize)
+	outPort.subscribers = append(outPort.subscribers, sub)
+	go outPort.deliverRoutine(sub)
 	return nil
 }
 
@@ -83,12 +156,135 @@ func (outPort *OutPort) PortId() int {
 	return outPort.id
 }
 
-// Write message to this OutPort
+// Write message to the intake queue of this OutPort, the message is then queued for every bound OutPutFunc.
+// All OutPutFuncs see the messages in the order they are accepted by the intake queue. If the intake queue or
+// the queue of an OutPutFunc is full, the message is handled by the port's overflow policy. With OverflowBlock,
+// an OutPutFunc whose queue is full stalls the dispatch, and the writers once the intake queue is full too,
+// use a drop policy to isolate slow OutPutFuncs completely. Return error if the port has been closed.
 func (outPort *OutPort) Write(msg interface{}) error {
-	outPort.outPortMtx.Lock()
-	defer outPort.outPortMtx.Unlock()
-	for _, outPutFunc := range outPort.outPutFuncs {
-		outPutFunc(msg)
+	return outPort.WriteContext(context.Background(), msg)
+}
+
+// WriteContext write message to this OutPort like Write, but return ctx.Err() if ctx is done while blocking
+// on the full intake queue with OverflowBlock policy.
+func (outPort *OutPort) WriteContext(ctx context.Context, msg interface{}) error {
+	if outPort.isClosed() {
+		return errors.New("out port has been closed")
 	}
+	if err := outPort.enqueue(ctx, outPort.intake, msg); err != nil {
+		return err
+	}
+	atomic.AddUint64(&outPort.state.InCount, 1)
 	return nil
 }
+
+// DroppedCount return the number of messages dropped because of queue overflow.
+func (outPort *OutPort) DroppedCount() uint64 {
+	return atomic.LoadUint64(&outPort.dropped)
+}
+
+// Stats return the message statistics of this OutPort.
+func (outPort *OutPort) Stats() Stats {
+	return Stats{
+		Written:   atomic.LoadUint64(&outPort.state.InCount),
+		Delivered: atomic.LoadUint64(&outPort.state.OutCount),
+		Dropped:   atomic.LoadUint64(&outPort.dropped),
+	}
+}
+
+// Close stop the dispatch and delivery routines of this OutPort. Queued messages that have not been delivered
+// are discarded.
+func (outPort *OutPort) Close() {
+	outPort.closeOnce.Do(func() {
+		close(outPort.quit)
+	})
+}
+
+// check whether the port has been closed
+func (outPort *OutPort) isClosed() bool {
+	select {
+	case <-outPort.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// put message to queue by overflow policy, return error if the port is closed or ctx is done while blocking.
+func (outPort *OutPort) enqueue(ctx context.Context, queue chan interface{}, msg interface{}) error {
+	switch outPort.policy {
+	case OverflowDropNewest:
+		select {
+		case queue <- msg:
+		default:
+			atomic.AddUint64(&outPort.dropped, 1)
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case queue <- msg:
+				return nil
+			default:
+			}
+			select {
+			case <-queue:
+				atomic.AddUint64(&outPort.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case queue <- msg:
+		case <-outPort.quit:
+			return errors.New("out port has been closed")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// fan out the messages in intake queue to the queues of the subscribers in order, until the port is closed.
+func (outPort *OutPort) dispatchRoutine() {
+	for {
+		select {
+		case msg := <-outPort.intake:
+			outPort.outPortMtx.Lock()
+			subscribers := outPort.subscribers
+			outPort.outPortMtx.Unlock()
+			for _, sub := range subscribers {
+				if err := outPort.enqueue(context.Background(), sub.queue, msg); err != nil {
+					return
+				}
+			}
+		case <-outPort.quit:
+			return
+		}
+	}
+}
+
+// deliver the queued messages to subscriber in order, until the port is closed.
+func (outPort *OutPort) deliverRoutine(sub *subscriber) {
+	for {
+		select {
+		case msg := <-sub.queue:
+			sub.deliver(msg)
+			atomic.AddUint64(&outPort.state.OutCount, 1)
+		case <-outPort.quit:
+			return
+		}
+	}
+}
+
+// deliver message to subscriber, the message is wrapped or unwrapped according to the bound function.
+func (sub *subscriber) deliver(msg interface{}) {
+	if sub.envelopeFunc != nil {
+		env, ok := msg.(*Envelope)
+		if !ok {
+			env = NewEnvelope(msg)
+		}
+		sub.envelopeFunc(env)
+		return
+	}
+	sub.outPutFunc(Unwrap(msg))
+}
@@ -1,9 +1,12 @@
 package port
 
 import (
+	"context"
 	"github.com/DSiSc/craft/types"
 	"github.com/stretchr/testify/assert"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Test new a InPort
@@ -64,7 +67,7 @@ func TestOutPort_BindToPort(t *testing.T) {
 
 	assert.Condition(
 		func() (success bool) {
-			return len(outPort.outPutFuncs) == 1
+			return len(outPort.subscribers) == 1
 		}, "FAILESD: failed to bind OutPutFunc to OutPort")
 }
 
@@ -87,3 +90,234 @@ func TestOutPort_Write(t *testing.T) {
 	recvMsg := <-recvMsgChan
 	assert.Equal(recvMsg, sendMsg, "FAILED: failed to Write message to OutPort")
 }
+
+// Test OutPort drop the newest message when queue is full
+func TestOutPort_WriteDropNewest(t *testing.T) {
+	assert := assert.New(t)
+	var outPort = NewOutPortWithQueue(LocalOutPortId, 1, OverflowDropNewest)
+	assert.NotNil(outPort, "FAILED: failed to create OutPort")
+
+	var recvMsgChan = make(chan interface{})
+	var deliveringChan = make(chan struct{}, 3)
+	outPort.BindToPort(func(msg interface{}) error {
+		deliveringChan <- struct{}{}
+		recvMsgChan <- msg
+		return nil
+	})
+
+	// first message is blocked in delivery, second one is queued, and the third one is dropped
+	msgs := []*types.Transaction{{}, {}, {}}
+	assert.Nil(outPort.Write(msgs[0]))
+	<-deliveringChan
+	assert.Nil(outPort.Write(msgs[1]))
+	assert.Nil(outPort.Write(msgs[2]))
+	waitDropped(t, outPort, 1)
+	assert.True(msgs[0] == <-recvMsgChan)
+	assert.True(msgs[1] == <-recvMsgChan)
+	outPort.Close()
+}
+
+// wait until the number of dropped messages of outPort reaches dropped
+func waitDropped(t *testing.T, outPort *OutPort, dropped uint64) {
+	deadline := time.Now().Add(2 * time.Second)
+	for outPort.DroppedCount() != dropped {
+		if time.Now().After(deadline) {
+			t.Fatalf("FAILED: dropped %d messages, expected %d", outPort.DroppedCount(), dropped)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Test OutPort drop the oldest message when queue is full
+func TestOutPort_WriteDropOldest(t *testing.T) {
+	assert := assert.New(t)
+	var outPort = NewOutPortWithQueue(LocalOutPortId, 1, OverflowDropOldest)
+	assert.NotNil(outPort, "FAILED: failed to create OutPort")
+
+	var recvMsgChan = make(chan interface{})
+	var deliveringChan = make(chan struct{}, 3)
+	outPort.BindToPort(func(msg interface{}) error {
+		deliveringChan <- struct{}{}
+		recvMsgChan <- msg
+		return nil
+	})
+
+	msgs := []*types.Transaction{{}, {}, {}}
+	assert.Nil(outPort.Write(msgs[0]))
+	<-deliveringChan
+	assert.Nil(outPort.Write(msgs[1]))
+	assert.Nil(outPort.Write(msgs[2]))
+	waitDropped(t, outPort, 1)
+	assert.True(msgs[0] == <-recvMsgChan)
+	assert.True(msgs[2] == <-recvMsgChan)
+	outPort.Close()
+}
+
+// Test write message to closed OutPort
+func TestOutPort_Close(t *testing.T) {
+	assert := assert.New(t)
+	var outPort = NewOutPort(LocalOutPortId)
+	assert.NotNil(outPort, "FAILED: failed to create OutPort")
+
+	outPort.Close()
+	outPort.Close()
+	assert.NotNil(outPort.Write(&types.Transaction{}), "FAILED: write message to closed OutPort")
+}
+
+// Test slow OutPutFunc will not stall the others
+func TestOutPort_WriteIsolated(t *testing.T) {
+	assert := assert.New(t)
+	var outPort = NewOutPort(LocalOutPortId)
+	assert.NotNil(outPort, "FAILED: failed to create OutPort")
+
+	var blockChan = make(chan struct{})
+	outPort.BindToPort(func(msg interface{}) error {
+		<-blockChan
+		return nil
+	})
+	var recvMsgChan = make(chan interface{}, 10)
+	outPort.BindToPort(func(msg interface{}) error {
+		recvMsgChan <- msg
+		return nil
+	})
+
+	msgs := make([]*types.Transaction, 10)
+	for i := range msgs {
+		msgs[i] = &types.Transaction{}
+		assert.Nil(outPort.Write(msgs[i]))
+	}
+	for i := range msgs {
+		select {
+		case recvMsg := <-recvMsgChan:
+			assert.True(msgs[i] == recvMsg, "FAILED: message is delivered out of order")
+		case <-time.After(2 * time.Second):
+			assert.Fail("FAILED: slow OutPutFunc stalls the others")
+		}
+	}
+	close(blockChan)
+	outPort.Close()
+}
+
+// Test every OutPutFunc sees the messages written concurrently in the same order
+func TestOutPort_WriteOrdered(t *testing.T) {
+	assert := assert.New(t)
+	var outPort = NewOutPort(LocalOutPortId)
+	const writers, count = 4, 100
+	recvMsgChans := []chan interface{}{make(chan interface{}, writers*count), make(chan interface{}, writers*count)}
+	for _, recvMsgChan := range recvMsgChans {
+		recvMsgChan := recvMsgChan
+		outPort.BindToPort(func(msg interface{}) error {
+			recvMsgChan <- msg
+			return nil
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				outPort.Write(&types.Transaction{})
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < writers*count; i++ {
+		assert.True(<-recvMsgChans[0] == <-recvMsgChans[1], "FAILED: OutPutFuncs see messages in different order")
+	}
+	outPort.Close()
+}
+
+// Test write blocked on a full queue can be canceled, and does not block binding
+func TestOutPort_WriteContext(t *testing.T) {
+	assert := assert.New(t)
+	var outPort = NewOutPortWithQueue(LocalOutPortId, 1, OverflowBlock)
+	var blockChan = make(chan struct{})
+	var deliveringChan = make(chan struct{}, 3)
+	outPort.BindToPort(func(msg interface{}) error {
+		deliveringChan <- struct{}{}
+		<-blockChan
+		return nil
+	})
+	// one message is being delivered, one is queued, one is held by dispatch routine and one is in intake queue
+	assert.Nil(outPort.Write(&types.Transaction{}))
+	<-deliveringChan
+	for i := 0; i < 3; i++ {
+		assert.Nil(outPort.Write(&types.Transaction{}))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- outPort.WriteContext(ctx, &types.Transaction{})
+	}()
+	bindErr := make(chan error, 1)
+	go func() {
+		bindErr <- outPort.BindToPort(func(msg interface{}) error { return nil })
+	}()
+	select {
+	case err := <-bindErr:
+		assert.Nil(err)
+	case <-time.After(2 * time.Second):
+		assert.Fail("FAILED: blocked write stalls binding")
+	}
+	cancel()
+	select {
+	case err := <-writeErr:
+		assert.Equal(context.Canceled, err)
+	case <-time.After(2 * time.Second):
+		assert.Fail("FAILED: blocked write is not canceled")
+	}
+	close(blockChan)
+	outPort.Close()
+}
+
+// Test bind EnvelopeFunc to OutPort
+func TestOutPort_BindEnvelopeToPort(t *testing.T) {
+	assert := assert.New(t)
+	var outPort = NewOutPort(LocalOutPortId)
+	assert.NotNil(outPort, "FAILED: failed to create OutPort")
+
+	var recvEnvChan = make(chan *Envelope, 2)
+	outPort.BindEnvelopeToPort(func(env *Envelope) error {
+		recvEnvChan <- env
+		return nil
+	})
+	var recvMsgChan = make(chan interface{}, 2)
+	outPort.BindToPort(func(msg interface{}) error {
+		recvMsgChan <- msg
+		return nil
+	})
+
+	txMsg := &types.Transaction{}
+	env := NewEnvelope(txMsg)
+	outPort.Write(env)
+	outPort.Write(txMsg)
+
+	assert.True(env == <-recvEnvChan)
+	assert.True(txMsg == (<-recvEnvChan).Msg, "FAILED: bare message is not wrapped")
+	assert.True(txMsg == <-recvMsgChan, "FAILED: envelope is not unwrapped")
+	assert.True(txMsg == <-recvMsgChan)
+	outPort.Close()
+}
+
+// Test get OutPort statistics
+func TestOutPort_Stats(t *testing.T) {
+	assert := assert.New(t)
+	var outPort = NewOutPort(LocalOutPortId)
+	assert.NotNil(outPort, "FAILED: failed to create OutPort")
+
+	var recvMsgChan = make(chan interface{})
+	outPort.BindToPort(func(msg interface{}) error {
+		recvMsgChan <- msg
+		return nil
+	})
+	outPort.Write(&types.Transaction{})
+	<-recvMsgChan
+	for outPort.Stats().Delivered != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(Stats{Written: 1, Delivered: 1}, outPort.Stats())
+	outPort.Close()
+}
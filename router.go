@@ -0,0 +1,49 @@
+package gossipswitch
+
+import (
+	"fmt"
+	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/port"
+)
+
+// router decides which out ports a message received from an in port should be sent to.
+type router struct {
+	policy config.RoutingPolicy
+	table  map[int]map[int]bool
+}
+
+// create a new router instance by routing policy, table is only used by config.TableRouting.
+func newRouter(policy config.RoutingPolicy, table map[int][]int) (*router, error) {
+	r := &router{policy: policy}
+	switch policy {
+	case config.BroadcastRouting, config.SplitHorizonRouting:
+	case config.TableRouting:
+		r.table = make(map[int]map[int]bool, len(table))
+		for inPortId, outPortIds := range table {
+			r.table[inPortId] = make(map[int]bool, len(outPortIds))
+			for _, outPortId := range outPortIds {
+				r.table[inPortId][outPortId] = true
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported routing policy %d", policy)
+	}
+	return r, nil
+}
+
+// route return true if the message received from in port should be sent to out port.
+func (r *router) route(inPortId, outPortId int) bool {
+	switch r.policy {
+	case config.SplitHorizonRouting:
+		// the built-in in ports are shared by the local node and all remote peers, they are not paired
+		// with the out port of the same id
+		if inPortId == port.LocalInPortId || inPortId == port.RemoteInPortId {
+			return true
+		}
+		return inPortId != outPortId
+	case config.TableRouting:
+		return r.table[inPortId][outPortId]
+	default:
+		return true
+	}
+}
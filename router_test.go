@@ -0,0 +1,53 @@
+package gossipswitch
+
+import (
+	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/port"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Test new router
+func Test_NewRouter(t *testing.T) {
+	assert := assert.New(t)
+	r, err := newRouter(config.BroadcastRouting, nil)
+	assert.Nil(err)
+	assert.NotNil(r)
+
+	_, err = newRouter(config.RoutingPolicy(100), nil)
+	assert.NotNil(err, "FAILED: create router with unsupported policy")
+}
+
+// Test broadcast routing
+func Test_BroadcastRoute(t *testing.T) {
+	assert := assert.New(t)
+	r, _ := newRouter(config.BroadcastRouting, nil)
+	assert.True(r.route(port.RemoteInPortId, port.LocalOutPortId))
+	assert.True(r.route(port.RemoteInPortId, port.RemoteOutPortId))
+}
+
+// Test split-horizon routing
+func Test_SplitHorizonRoute(t *testing.T) {
+	assert := assert.New(t)
+	r, _ := newRouter(config.SplitHorizonRouting, nil)
+	// built-in ports are not paired
+	assert.True(r.route(port.LocalInPortId, port.LocalOutPortId))
+	assert.True(r.route(port.LocalInPortId, port.RemoteOutPortId))
+	assert.True(r.route(port.RemoteInPortId, port.LocalOutPortId))
+	assert.True(r.route(port.RemoteInPortId, port.RemoteOutPortId))
+	// peer ports are paired by id
+	assert.False(r.route(5, 5))
+	assert.True(r.route(5, port.RemoteOutPortId))
+	assert.True(r.route(5, 6))
+}
+
+// Test table routing
+func Test_TableRoute(t *testing.T) {
+	assert := assert.New(t)
+	r, _ := newRouter(config.TableRouting, map[int][]int{
+		port.RemoteInPortId: {port.LocalOutPortId},
+	})
+	assert.True(r.route(port.RemoteInPortId, port.LocalOutPortId))
+	assert.False(r.route(port.RemoteInPortId, port.RemoteOutPortId))
+	assert.False(r.route(port.LocalInPortId, port.LocalOutPortId))
+}
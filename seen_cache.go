@@ -0,0 +1,106 @@
+package gossipswitch
+
+import (
+	"container/list"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/port"
+	"sync"
+	"time"
+)
+
+// seenCache is a bounded, time-expiring set of the message hashes received by switch.
+// When the cache is full, the oldest hash will be evicted.
+type seenCache struct {
+	lock    sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[types.Hash]*list.Element
+	order   *list.List // seenEntry, from oldest to newest
+}
+
+type seenEntry struct {
+	hash     types.Hash
+	deadline time.Time
+}
+
+// create a new seen cache instance. ttl <= 0 means the hash never expires.
+func newSeenCache(size int, ttl time.Duration) *seenCache {
+	return &seenCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[types.Hash]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// checkAndAdd record the hash as seen. Return true if the hash has been seen before.
+func (cache *seenCache) checkAndAdd(hash types.Hash) bool {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	now := time.Now()
+	cache.expire(now)
+	if _, ok := cache.entries[hash]; ok {
+		return true
+	}
+	entry := &seenEntry{hash: hash}
+	if cache.ttl > 0 {
+		entry.deadline = now.Add(cache.ttl)
+	}
+	cache.entries[hash] = cache.order.PushBack(entry)
+	for cache.order.Len() > cache.size {
+		cache.remove(cache.order.Front())
+	}
+	return false
+}
+
+// forget remove the hash from cache, so that the message will not be taken as duplicate.
+func (cache *seenCache) forget(hash types.Hash) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if elem, ok := cache.entries[hash]; ok {
+		cache.remove(elem)
+	}
+}
+
+// len return the number of hashes in cache.
+func (cache *seenCache) len() int {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return cache.order.Len()
+}
+
+// remove the expired hashes, caller must hold the lock.
+func (cache *seenCache) expire(now time.Time) {
+	if cache.ttl <= 0 {
+		return
+	}
+	for elem := cache.order.Front(); elem != nil; elem = cache.order.Front() {
+		if now.Before(elem.Value.(*seenEntry).deadline) {
+			return
+		}
+		cache.remove(elem)
+	}
+}
+
+// remove the element from cache, caller must hold the lock.
+func (cache *seenCache) remove(elem *list.Element) {
+	cache.order.Remove(elem)
+	delete(cache.entries, elem.Value.(*seenEntry).hash)
+}
+
+// get the hash used to identify the message, return false if the message type is unknown.
+func msgHash(msg interface{}) (types.Hash, bool) {
+	switch msg := port.Unwrap(msg).(type) {
+	case *types.Transaction:
+		return filter.TxHash(msg), true
+	case *types.Block:
+		if msg.Header == nil {
+			return types.Hash{}, false
+		}
+		// do not trust the HeaderHash carried by message, as the message has not been verified yet.
+		return filter.HeaderHash(msg), true
+	default:
+		return types.Hash{}, false
+	}
+}
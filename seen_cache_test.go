@@ -0,0 +1,62 @@
+package gossipswitch
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Test record hash in seen cache
+func Test_SeenCacheCheckAndAdd(t *testing.T) {
+	assert := assert.New(t)
+	cache := newSeenCache(2, 0)
+	assert.False(cache.checkAndAdd(types.Hash{1}))
+	assert.True(cache.checkAndAdd(types.Hash{1}))
+	assert.False(cache.checkAndAdd(types.Hash{2}))
+	assert.Equal(2, cache.len())
+}
+
+// Test evict the oldest hash when seen cache is full
+func Test_SeenCacheEvict(t *testing.T) {
+	assert := assert.New(t)
+	cache := newSeenCache(2, 0)
+	cache.checkAndAdd(types.Hash{1})
+	cache.checkAndAdd(types.Hash{2})
+	cache.checkAndAdd(types.Hash{3})
+	assert.Equal(2, cache.len())
+	assert.False(cache.checkAndAdd(types.Hash{1}), "FAILED: oldest hash is not evicted")
+}
+
+// Test expire hash in seen cache
+func Test_SeenCacheExpire(t *testing.T) {
+	assert := assert.New(t)
+	cache := newSeenCache(2, 50*time.Millisecond)
+	cache.checkAndAdd(types.Hash{1})
+	time.Sleep(100 * time.Millisecond)
+	assert.False(cache.checkAndAdd(types.Hash{1}), "FAILED: hash is not expired")
+}
+
+// Test get message hash
+func Test_MsgHash(t *testing.T) {
+	assert := assert.New(t)
+	_, ok := msgHash(&types.Transaction{})
+	assert.True(ok)
+	_, ok = msgHash(&types.Block{Header: &types.Header{}})
+	assert.True(ok)
+	_, ok = msgHash(&types.Block{})
+	assert.False(ok)
+	_, ok = msgHash("unknown message")
+	assert.False(ok)
+}
+
+// Test forget hash in seen cache
+func Test_SeenCacheForget(t *testing.T) {
+	assert := assert.New(t)
+	cache := newSeenCache(2, 0)
+	cache.checkAndAdd(types.Hash{1})
+	cache.forget(types.Hash{1})
+	cache.forget(types.Hash{2})
+	assert.Equal(0, cache.len())
+	assert.False(cache.checkAndAdd(types.Hash{1}))
+}
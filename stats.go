@@ -0,0 +1,256 @@
+package gossipswitch
+
+import (
+	"fmt"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/DSiSc/gossipswitch/port"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rejection reasons of the messages not rejected by switch filter
+const (
+	ReasonTTLExpired = "ttl_expired" // message has reached its max hops
+	ReasonOther      = "other"       // error which is not *filter.VerifyError
+)
+
+// LatencyBuckets is the upper bounds(in seconds) of the verification latency histogram buckets.
+var LatencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// Stats is the statistics of switch.
+type Stats struct {
+	SwitchType SwitchType
+	InPorts    map[int]InPortStats // keyed by in port id
+	OutPorts   map[int]port.Stats  // keyed by out port id
+	Filters    []filter.StageStats // statistics of the filter chain stages, nil if the filter is not a chain
+	FutureTxs  *FutureTxStats      // nil if nonce ordering is disabled
+	Orphans    *OrphanStats        // nil if orphan pool is disabled
+}
+
+// OrphanStats is the statistics of the orphan blocks held by block switch.
+type OrphanStats struct {
+	Held    int    // number of orphan blocks being held
+	Evicted uint64 // number of orphan blocks evicted because of timeout or pool overflow
+}
+
+// FutureTxStats is the statistics of the future nonce transactions held by nonce ordering.
+type FutureTxStats struct {
+	Held    int    // number of transactions being held
+	Evicted uint64 // number of transactions evicted because of timeout or queue overflow
+}
+
+// InPortStats is the statistics of the messages received from an in port.
+type InPortStats struct {
+	Received      uint64
+	Accepted      uint64
+	Duplicates    uint64
+	Rejected      map[string]uint64 // keyed by rejection reason, e.g. filter.ReasonInvalidSignature
+	VerifyLatency Histogram
+}
+
+// Histogram is a cumulative histogram of durations.
+type Histogram struct {
+	Buckets []float64 // upper bounds of the buckets, in seconds
+	Counts  []uint64  // Counts[i] is the number of observations less than or equal to Buckets[i]
+	Count   uint64
+	Sum     float64 // in seconds
+}
+
+// portCounter record the statistics of an in port.
+type portCounter struct {
+	lock  sync.Mutex
+	stats InPortStats
+}
+
+// create a new port counter instance
+func newPortCounter() *portCounter {
+	return &portCounter{
+		stats: InPortStats{
+			Rejected: make(map[string]uint64),
+			VerifyLatency: Histogram{
+				Buckets: LatencyBuckets,
+				Counts:  make([]uint64, len(LatencyBuckets)),
+			},
+		},
+	}
+}
+
+// record a received message
+func (counter *portCounter) received() {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	counter.stats.Received++
+}
+
+// record a duplicate message
+func (counter *portCounter) duplicate() {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	counter.stats.Duplicates++
+}
+
+// record the verification result and latency of a message
+func (counter *portCounter) verified(err error, latency time.Duration) {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	counter.stats.VerifyLatency.observe(latency)
+	if err == nil {
+		counter.stats.Accepted++
+		return
+	}
+	reason := filter.ErrorReason(err)
+	if reason == "" {
+		reason = ReasonOther
+	}
+	counter.stats.Rejected[reason]++
+}
+
+// record a message rejected before verification
+func (counter *portCounter) rejected(reason string) {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	counter.stats.Rejected[reason]++
+}
+
+// take a snapshot of the statistics
+func (counter *portCounter) snapshot() InPortStats {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	stats := counter.stats
+	stats.Rejected = make(map[string]uint64, len(counter.stats.Rejected))
+	for reason, count := range counter.stats.Rejected {
+		stats.Rejected[reason] = count
+	}
+	stats.VerifyLatency.Counts = append([]uint64(nil), counter.stats.VerifyLatency.Counts...)
+	return stats
+}
+
+// record an observation in histogram
+func (h *Histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	for i, bound := range h.Buckets {
+		if seconds <= bound {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += seconds
+}
+
+// WritePrometheus write the statistics to w in Prometheus text exposition format.
+func (stats *Stats) WritePrometheus(w io.Writer) error {
+	pw := &promWriter{w: w}
+	switchType := stats.SwitchType.String()
+	inPortIds := make([]int, 0, len(stats.InPorts))
+	for id := range stats.InPorts {
+		inPortIds = append(inPortIds, id)
+	}
+	sort.Ints(inPortIds)
+	outPortIds := make([]int, 0, len(stats.OutPorts))
+	for id := range stats.OutPorts {
+		outPortIds = append(outPortIds, id)
+	}
+	sort.Ints(outPortIds)
+
+	pw.header("gossipswitch_received_total", "counter", "Number of messages received from in port.")
+	for _, id := range inPortIds {
+		pw.sample("gossipswitch_received_total", stats.InPorts[id].Received, "switch", switchType, "port", id)
+	}
+	pw.header("gossipswitch_accepted_total", "counter", "Number of messages accepted by switch filter.")
+	for _, id := range inPortIds {
+		pw.sample("gossipswitch_accepted_total", stats.InPorts[id].Accepted, "switch", switchType, "port", id)
+	}
+	pw.header("gossipswitch_duplicate_total", "counter", "Number of duplicate messages suppressed.")
+	for _, id := range inPortIds {
+		pw.sample("gossipswitch_duplicate_total", stats.InPorts[id].Duplicates, "switch", switchType, "port", id)
+	}
+	pw.header("gossipswitch_rejected_total", "counter", "Number of messages rejected, by reason.")
+	for _, id := range inPortIds {
+		rejected := stats.InPorts[id].Rejected
+		reasons := make([]string, 0, len(rejected))
+		for reason := range rejected {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			pw.sample("gossipswitch_rejected_total", rejected[reason], "switch", switchType, "port", id, "reason", reason)
+		}
+	}
+	pw.header("gossipswitch_verify_latency_seconds", "histogram", "Latency of message verification.")
+	for _, id := range inPortIds {
+		h := stats.InPorts[id].VerifyLatency
+		for i, bound := range h.Buckets {
+			pw.sample("gossipswitch_verify_latency_seconds_bucket", h.Counts[i], "switch", switchType, "port", id, "le", bound)
+		}
+		pw.sample("gossipswitch_verify_latency_seconds_bucket", h.Count, "switch", switchType, "port", id, "le", "+Inf")
+		pw.sample("gossipswitch_verify_latency_seconds_sum", h.Sum, "switch", switchType, "port", id)
+		pw.sample("gossipswitch_verify_latency_seconds_count", h.Count, "switch", switchType, "port", id)
+	}
+	pw.header("gossipswitch_delivered_total", "counter", "Number of messages delivered by out port.")
+	for _, id := range outPortIds {
+		pw.sample("gossipswitch_delivered_total", stats.OutPorts[id].Delivered, "switch", switchType, "port", id)
+	}
+	pw.header("gossipswitch_dropped_total", "counter", "Number of messages dropped by out port because of queue overflow.")
+	for _, id := range outPortIds {
+		pw.sample("gossipswitch_dropped_total", stats.OutPorts[id].Dropped, "switch", switchType, "port", id)
+	}
+	if stats.FutureTxs != nil {
+		pw.header("gossipswitch_future_txs", "gauge", "Number of future nonce transactions being held.")
+		pw.sample("gossipswitch_future_txs", stats.FutureTxs.Held, "switch", switchType)
+		pw.header("gossipswitch_future_tx_evicted_total", "counter", "Number of future nonce transactions evicted.")
+		pw.sample("gossipswitch_future_tx_evicted_total", stats.FutureTxs.Evicted, "switch", switchType)
+	}
+	if stats.Orphans != nil {
+		pw.header("gossipswitch_orphan_blocks", "gauge", "Number of orphan blocks being held.")
+		pw.sample("gossipswitch_orphan_blocks", stats.Orphans.Held, "switch", switchType)
+		pw.header("gossipswitch_orphan_evicted_total", "counter", "Number of orphan blocks evicted.")
+		pw.sample("gossipswitch_orphan_evicted_total", stats.Orphans.Evicted, "switch", switchType)
+	}
+	if len(stats.Filters) > 0 {
+		pw.header("gossipswitch_filter_passed_total", "counter", "Number of messages accepted by filter stage.")
+		for _, stage := range stats.Filters {
+			pw.sample("gossipswitch_filter_passed_total", stage.Passed, "switch", switchType, "stage", stage.Name)
+		}
+		pw.header("gossipswitch_filter_rejected_total", "counter", "Number of messages rejected by filter stage.")
+		for _, stage := range stats.Filters {
+			pw.sample("gossipswitch_filter_rejected_total", stage.Rejected, "switch", switchType, "stage", stage.Name)
+		}
+		pw.header("gossipswitch_filter_seconds_total", "counter", "Total time spent in filter stage.")
+		for _, stage := range stats.Filters {
+			pw.sample("gossipswitch_filter_seconds_total", stage.Elapsed.Seconds(), "switch", switchType, "stage", stage.Name)
+		}
+	}
+	return pw.err
+}
+
+// promWriter write metrics in Prometheus text format, and keep the first error.
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+// write the HELP and TYPE lines of a metric
+func (pw *promWriter) header(name, metricType, help string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// write a sample line, labels are given as name, value pairs
+func (pw *promWriter) sample(name string, value interface{}, labels ...interface{}) {
+	pw.printf("%s{", name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			pw.printf(",")
+		}
+		pw.printf("%s=%q", labels[i], fmt.Sprint(labels[i+1]))
+	}
+	pw.printf("} %v\n", value)
+}
+
+func (pw *promWriter) printf(format string, a ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, a...)
+}
@@ -0,0 +1,54 @@
+package gossipswitch
+
+import (
+	"bytes"
+	"errors"
+	"github.com/DSiSc/gossipswitch/filter"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test record port statistics
+func Test_PortCounter(t *testing.T) {
+	assert := assert.New(t)
+	counter := newPortCounter()
+	counter.received()
+	counter.received()
+	counter.received()
+	counter.duplicate()
+	counter.verified(nil, time.Millisecond)
+	counter.verified(filter.NewVerifyError(filter.ReasonInvalidSignature, errors.New("invalid signature")), 2*time.Second)
+	counter.rejected(ReasonTTLExpired)
+
+	stats := counter.snapshot()
+	assert.Equal(uint64(3), stats.Received)
+	assert.Equal(uint64(1), stats.Duplicates)
+	assert.Equal(uint64(1), stats.Accepted)
+	assert.Equal(uint64(1), stats.Rejected[filter.ReasonInvalidSignature])
+	assert.Equal(uint64(1), stats.Rejected[ReasonTTLExpired])
+	assert.Equal(uint64(2), stats.VerifyLatency.Count)
+	assert.Equal(uint64(1), stats.VerifyLatency.Counts[2], "FAILED: 1ms is not in the 1ms bucket")
+	assert.Equal(uint64(2), stats.VerifyLatency.Counts[len(LatencyBuckets)-1])
+
+	counter.verified(errors.New("unknown error"), 0)
+	assert.Equal(uint64(0), stats.Rejected[ReasonOther], "FAILED: snapshot is modified")
+	assert.Equal(uint64(1), counter.snapshot().Rejected[ReasonOther])
+}
+
+// Test write statistics in prometheus format
+func TestStats_WritePrometheus(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+	sw.counters[0].received()
+
+	var buf bytes.Buffer
+	assert.Nil(sw.Stats().WritePrometheus(&buf))
+	out := buf.String()
+	assert.True(strings.Contains(out, "# TYPE gossipswitch_received_total counter\n"))
+	assert.True(strings.Contains(out, `gossipswitch_received_total{switch="custom",port="0"} 1`+"\n"))
+	assert.True(strings.Contains(out, `gossipswitch_verify_latency_seconds_bucket{switch="custom",port="1",le="+Inf"} 0`+"\n"))
+	assert.True(strings.Contains(out, `gossipswitch_dropped_total{switch="custom",port="1"} 0`+"\n"))
+}
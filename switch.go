@@ -1,7 +1,9 @@
 package gossipswitch
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/DSiSc/craft/log"
 	"github.com/DSiSc/craft/types"
 	"github.com/DSiSc/gossipswitch/config"
@@ -11,6 +13,7 @@ import (
 	"github.com/DSiSc/gossipswitch/port"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // SwitchType switch type
@@ -19,27 +22,65 @@ type SwitchType int
 const (
 	TxSwitch SwitchType = iota
 	BlockSwitch
+	CustomSwitch // switch created by NewGossipSwitch with custom filter
 )
 
+// String return the name of the switch type
+func (switchType SwitchType) String() string {
+	switch switchType {
+	case TxSwitch:
+		return "tx"
+	case BlockSwitch:
+		return "block"
+	case CustomSwitch:
+		return "custom"
+	default:
+		return "unknown"
+	}
+}
+
+// common errors returned by switch
+var (
+	ErrSwitchStopped    = errors.New("switch is not running")
+	ErrDuplicateMessage = errors.New("duplicate message")
+	ErrTTLExpired       = errors.New("message has reached its max hops")
+)
+
+// StopTimeout is the max time Stop will wait for the receive routines and in-flight broadcasts to exit.
+const StopTimeout = 5 * time.Second
+
 // GossipSwitch is the implementation of gossip switch.
 // for gossipswitch, if a validated message is received, it will be broadcasted,
 // otherwise it will be dropped.
 type GossipSwitch struct {
-	switchMtx sync.Mutex
-	filter    filter.SwitchFilter
-	inPorts   map[int]*port.InPort
-	outPorts  map[int]*port.OutPort
-	isRunning uint32 // atomic
+	lifecycleMtx sync.Mutex // serialize Start and Stop
+	switchMtx    sync.Mutex
+	routines     sync.WaitGroup     // receive routines and in-flight submits
+	runCtx       context.Context    // canceled by Stop to unblock in-flight broadcasts
+	cancelRun    context.CancelFunc // cancel runCtx
+	exited       chan struct{}      // closed once the routines of the last run have exited, nil if never stopped
+	stopTimeout  time.Duration
+	filter       filter.SwitchFilter
+	inPorts      map[int]*port.InPort
+	outPorts     map[int]*port.OutPort
+	receivers    map[int]chan struct{} // quit channels of the running receive routines, keyed by in port id
+	router       *router               // decide which out ports a verified message is sent to
+	queueSize    int                   // queue size of the out ports
+	overflow     port.OverflowPolicy   // overflow policy of the out ports
+	seen         *seenCache            // nil if deduplication is disabled
+	orderer      *nonceOrderer         // nil if nonce ordering is disabled
+	orphans      *orphanPool           // nil if orphan pool is disabled
+	eventCenter  types.EventCenter     // nil for custom switch
+	switchType   SwitchType
+	counters     map[int]*portCounter // statistics of in ports, keyed by in port id
+	closed       bool                 // out ports have been closed by Close
+	isRunning    uint32               // atomic
 }
 
 // NewGossipSwitch create a new switch instance with given filter.
 // filter is used to verify the received message
 func NewGossipSwitch(filter filter.SwitchFilter) *GossipSwitch {
-	sw := &GossipSwitch{
-		filter:   filter,
-		inPorts:  make(map[int]*port.InPort),
-		outPorts: make(map[int]*port.OutPort),
-	}
+	sw := newSwitch(CustomSwitch, filter)
 	sw.initPort()
 	return sw
 }
@@ -47,56 +88,191 @@ func NewGossipSwitch(filter filter.SwitchFilter) *GossipSwitch {
 // NewGossipSwitchByType create a new switch instance by type.
 // switchType is used to specify the switch type
 func NewGossipSwitchByType(switchType SwitchType, eventCenter types.EventCenter, switchConfig *config.SwitchConfig) (*GossipSwitch, error) {
-	var msgFilter filter.SwitchFilter
+	var builtin filter.Stage
 	switch switchType {
 	case TxSwitch:
 		log.Info("New transaction switch")
-		msgFilter = transaction.NewTxFilter(eventCenter, switchConfig.VerifySignature, switchConfig.ChainID)
+		builtin = filter.Stage{Name: "tx", Filter: transaction.NewTxFilterWithConfig(eventCenter, switchConfig)}
 	case BlockSwitch:
 		log.Info("New block switch")
-		msgFilter = block.NewBlockFilter(eventCenter, switchConfig.VerifySignature)
+		blockFilter, err := block.NewBlockFilterWithConfig(eventCenter, switchConfig)
+		if err != nil {
+			log.Error("Failed to create block filter, as: %v", err)
+			return nil, err
+		}
+		builtin = filter.Stage{Name: "block", Filter: blockFilter}
 	default:
 		log.Error("Unsupported switch type")
 		return nil, errors.New("Unsupported switch type ")
 	}
-	sw := &GossipSwitch{
-		filter:   msgFilter,
-		inPorts:  make(map[int]*port.InPort),
-		outPorts: make(map[int]*port.OutPort),
+	msgFilter := filter.NewChain(switchConfig.PreFilters...)
+	msgFilter.Append(builtin)
+	for _, stage := range switchConfig.PostFilters {
+		msgFilter.Append(stage)
+	}
+	r, err := newRouter(switchConfig.RoutingPolicy, switchConfig.RoutingTable)
+	if err != nil {
+		log.Error("Failed to create switch router, as: %v", err)
+		return nil, err
+	}
+	sw := newSwitch(switchType, msgFilter)
+	sw.router = r
+	sw.queueSize = switchConfig.OutPortQueueSize
+	sw.overflow = switchConfig.OutPortOverflowPolicy
+	if switchConfig.SeenCacheSize > 0 {
+		sw.seen = newSeenCache(switchConfig.SeenCacheSize, switchConfig.SeenCacheTTL)
 	}
+	if switchType == TxSwitch && switchConfig.NonceOrdering {
+		sw.orderer = newNonceOrderer(switchConfig.FutureTxQueueSize, switchConfig.FutureTxTimeout)
+		// the evicted tx is verified and held again when it is received next time
+		sw.orderer.onEvict = func(env *port.Envelope) { sw.forgetSeen(env.Msg) }
+		sw.orderer.replace = switchConfig.ReplaceByFee
+	}
+	if switchType == BlockSwitch && switchConfig.OrphanPoolSize > 0 {
+		sw.orphans = newOrphanPool(switchConfig.OrphanPoolSize, switchConfig.OrphanTTL)
+	}
+	sw.eventCenter = eventCenter
 	sw.initPort()
 	return sw, nil
 }
 
+// create a switch instance without any port
+func newSwitch(switchType SwitchType, filter filter.SwitchFilter) *GossipSwitch {
+	return &GossipSwitch{
+		switchType:  switchType,
+		counters:    make(map[int]*portCounter),
+		filter:      filter,
+		router:      &router{policy: config.BroadcastRouting},
+		queueSize:   port.DefaultQueueSize,
+		overflow:    port.OverflowBlock,
+		inPorts:     make(map[int]*port.InPort),
+		outPorts:    make(map[int]*port.OutPort),
+		receivers:   make(map[int]chan struct{}),
+		runCtx:      context.Background(),
+		cancelRun:   func() {},
+		stopTimeout: StopTimeout,
+	}
+}
+
 // init switch's port.InPort and port.OutPort
 func (sw *GossipSwitch) initPort() {
 	log.Info("Init switch's ports")
 	sw.inPorts[port.LocalInPortId] = port.NewInPort(port.LocalInPortId)
 	sw.inPorts[port.RemoteInPortId] = port.NewInPort(port.RemoteInPortId)
-	sw.outPorts[port.LocalOutPortId] = port.NewOutPort(port.LocalOutPortId)
-	sw.outPorts[port.RemoteOutPortId] = port.NewOutPort(port.RemoteOutPortId)
+	sw.counters[port.LocalInPortId] = newPortCounter()
+	sw.counters[port.RemoteInPortId] = newPortCounter()
+	sw.outPorts[port.LocalOutPortId] = port.NewOutPortWithQueue(port.LocalOutPortId, sw.queueSize, sw.overflow)
+	sw.outPorts[port.RemoteOutPortId] = port.NewOutPortWithQueue(port.RemoteOutPortId, sw.queueSize, sw.overflow)
 }
 
 // port.InPort get switch's in port by port id, return nil if there is no port with specific id.
 func (sw *GossipSwitch) InPort(portId int) *port.InPort {
 	log.Debug("Get switch %v in port", portId)
+	sw.switchMtx.Lock()
+	defer sw.switchMtx.Unlock()
 	return sw.inPorts[portId]
 }
 
 // port.InPort get switch's out port by port id, return nil if there is no port with specific id.
 func (sw *GossipSwitch) OutPort(portId int) *port.OutPort {
 	log.Debug("Get switch %v out port", portId)
+	sw.switchMtx.Lock()
+	defer sw.switchMtx.Unlock()
 	return sw.outPorts[portId]
 }
 
+// AddInPort add a new in port with the specified id to the switch. If the switch is running, the
+// receive routine of the new port is started immediately. Return error if the port id is in use.
+func (sw *GossipSwitch) AddInPort(portId int) (*port.InPort, error) {
+	log.Info("Add in port %d to switch", portId)
+	sw.switchMtx.Lock()
+	defer sw.switchMtx.Unlock()
+	if _, ok := sw.inPorts[portId]; ok {
+		log.Error("In port %d already exists", portId)
+		return nil, fmt.Errorf("in port %d already exists", portId)
+	}
+	inPort := port.NewInPort(portId)
+	sw.inPorts[portId] = inPort
+	sw.counters[portId] = newPortCounter()
+	if sw.IsRunning() {
+		sw.startReceiver(inPort)
+	}
+	return inPort, nil
+}
+
+// AddOutPort add a new out port with the specified id to the switch. Return error if the port id is in use.
+func (sw *GossipSwitch) AddOutPort(portId int) (*port.OutPort, error) {
+	log.Info("Add out port %d to switch", portId)
+	sw.switchMtx.Lock()
+	defer sw.switchMtx.Unlock()
+	if sw.closed {
+		log.Error("Switch has been closed")
+		return nil, errors.New("switch has been closed")
+	}
+	if _, ok := sw.outPorts[portId]; ok {
+		log.Error("Out port %d already exists", portId)
+		return nil, fmt.Errorf("out port %d already exists", portId)
+	}
+	outPort := port.NewOutPortWithQueue(portId, sw.queueSize, sw.overflow)
+	sw.outPorts[portId] = outPort
+	return outPort, nil
+}
+
+// RemoveInPort remove the in port with the specified id from the switch, and stop its receive routine.
+// Message sent to the removed port's channel will never be read by the switch.
+func (sw *GossipSwitch) RemoveInPort(portId int) error {
+	log.Info("Remove in port %d from switch", portId)
+	sw.switchMtx.Lock()
+	defer sw.switchMtx.Unlock()
+	if _, ok := sw.inPorts[portId]; !ok {
+		log.Error("In port %d not exists", portId)
+		return fmt.Errorf("in port %d not exists", portId)
+	}
+	sw.stopReceiver(portId)
+	delete(sw.inPorts, portId)
+	delete(sw.counters, portId)
+	return nil
+}
+
+// RemoveOutPort remove the out port with the specified id from the switch, and close it. The removed port
+// will not deliver any message after removal.
+func (sw *GossipSwitch) RemoveOutPort(portId int) error {
+	log.Info("Remove out port %d from switch", portId)
+	sw.switchMtx.Lock()
+	defer sw.switchMtx.Unlock()
+	if _, ok := sw.outPorts[portId]; !ok {
+		log.Error("Out port %d not exists", portId)
+		return fmt.Errorf("out port %d not exists", portId)
+	}
+	sw.outPorts[portId].Close()
+	delete(sw.outPorts, portId)
+	return nil
+}
+
 // Start start the switch. Once started, switch will receive message from in port, and broadcast to
-// out port
+// out port. A stopped switch can be started again once the routines of the last run have exited.
 func (sw *GossipSwitch) Start() error {
 	log.Info("Begin starting switch")
-
+	sw.lifecycleMtx.Lock()
+	defer sw.lifecycleMtx.Unlock()
+	if sw.exited != nil {
+		select {
+		case <-sw.exited:
+		default:
+			log.Error("Switch routines of the last run have not exited")
+			return errors.New("switch routines of the last run have not exited")
+		}
+	}
+	sw.switchMtx.Lock()
+	defer sw.switchMtx.Unlock()
+	if sw.closed {
+		log.Error("Switch has been closed")
+		return errors.New("switch has been closed")
+	}
 	if atomic.CompareAndSwapUint32(&sw.isRunning, 0, 1) {
+		sw.runCtx, sw.cancelRun = context.WithCancel(context.Background())
 		for _, inPort := range sw.inPorts {
-			go sw.receiveRoutine(inPort)
+			sw.startReceiver(inPort)
 		}
 		log.Info("Start switch success")
 		return nil
@@ -105,15 +281,67 @@ func (sw *GossipSwitch) Start() error {
 	return errors.New("switch already started")
 }
 
-// Stop stop the switch. Once stopped, switch will stop to receive and broadcast message
+// Stop stop the switch. Once stopped, switch will stop to receive and broadcast message, the broadcasts
+// blocked on full out port queues are canceled. Stop returns after all receive routines and in-flight
+// broadcasts have exited, or return error if they have not exited within StopTimeout, Start fails until
+// they have exited then.
 func (sw *GossipSwitch) Stop() error {
 	log.Info("Begin stopping switch")
-	if atomic.CompareAndSwapUint32(&sw.isRunning, 1, 0) {
+	sw.lifecycleMtx.Lock()
+	defer sw.lifecycleMtx.Unlock()
+	sw.switchMtx.Lock()
+	if !atomic.CompareAndSwapUint32(&sw.isRunning, 1, 0) {
+		sw.switchMtx.Unlock()
+		log.Error("Switch already stopped")
+		return errors.New("switch already stopped")
+	}
+	for portId := range sw.receivers {
+		sw.stopReceiver(portId)
+	}
+	// unblock the broadcasts waiting for room in out port queues
+	sw.cancelRun()
+	sw.switchMtx.Unlock()
+
+	done := make(chan struct{})
+	sw.exited = done
+	go func() {
+		sw.routines.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
 		log.Info("Stop switch success")
 		return nil
+	case <-time.After(sw.stopTimeout):
+		log.Error("Switch routines have not exited in %v", sw.stopTimeout)
+		return fmt.Errorf("switch routines have not exited in %v", sw.stopTimeout)
 	}
-	log.Error("Switch already stopped")
-	return errors.New("switch already stopped")
+}
+
+// Close stop the switch if it is running, and close all out ports, so that their dispatch and delivery
+// routines exit. A closed switch can not be started again. The error of Stop is returned if the routines
+// of the switch have not exited, the out ports are closed anyway.
+func (sw *GossipSwitch) Close() error {
+	log.Info("Begin closing switch")
+	sw.switchMtx.Lock()
+	if sw.closed {
+		sw.switchMtx.Unlock()
+		log.Error("Switch already closed")
+		return errors.New("switch already closed")
+	}
+	sw.closed = true
+	sw.switchMtx.Unlock()
+
+	var err error
+	if sw.IsRunning() {
+		err = sw.Stop()
+	}
+	sw.switchMtx.Lock()
+	defer sw.switchMtx.Unlock()
+	for _, outPort := range sw.outPorts {
+		outPort.Close()
+	}
+	return err
 }
 
 // IsRunning is used to query switch's current status. Return true if running, otherwise false
@@ -121,34 +349,242 @@ func (sw *GossipSwitch) IsRunning() bool {
 	return atomic.LoadUint32(&sw.isRunning) == 1
 }
 
-// listen to receive message from the in port
-func (sw *GossipSwitch) receiveRoutine(inPort *port.InPort) {
+// Submit submit a message(bare message or *port.Envelope) to switch through the specified in port, and wait
+// for the verification result. The message goes through the same filter and broadcast path as the message sent to InPort.Channel().
+// Return nil if the message is accepted, otherwise return the error of the verification(e.g. *filter.VerifyError,
+// ErrDuplicateMessage), or ctx.Err() if ctx is done before the verification completes.
+func (sw *GossipSwitch) Submit(ctx context.Context, portId int, msg interface{}) error {
+	sw.switchMtx.Lock()
+	if !sw.IsRunning() {
+		sw.switchMtx.Unlock()
+		return ErrSwitchStopped
+	}
+	if _, ok := sw.inPorts[portId]; !ok {
+		sw.switchMtx.Unlock()
+		return fmt.Errorf("in port %d not exists", portId)
+	}
+	sw.routines.Add(1)
+	sw.switchMtx.Unlock()
+
+	result := make(chan error, 1)
+	go func() {
+		defer sw.routines.Done()
+		result <- sw.onRecvMsg(portId, msg)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DuplicateCount return the number of duplicate messages suppressed by switch.
+func (sw *GossipSwitch) DuplicateCount() uint64 {
+	var duplicates uint64
+	for _, stats := range sw.Stats().InPorts {
+		duplicates += stats.Duplicates
+	}
+	return duplicates
+}
+
+// Stats return the statistics of switch's ports and filters.
+func (sw *GossipSwitch) Stats() *Stats {
+	sw.switchMtx.Lock()
+	stats := &Stats{
+		SwitchType: sw.switchType,
+		InPorts:    make(map[int]InPortStats, len(sw.counters)),
+		OutPorts:   make(map[int]port.Stats, len(sw.outPorts)),
+	}
+	for portId, counter := range sw.counters {
+		stats.InPorts[portId] = counter.snapshot()
+	}
+	for portId, outPort := range sw.outPorts {
+		stats.OutPorts[portId] = outPort.Stats()
+	}
+	if chain, ok := sw.filter.(*filter.Chain); ok {
+		stats.Filters = chain.Stats()
+	}
+	sw.switchMtx.Unlock()
+
+	// the orderer and orphan pool lock their own state, do not hold switchMtx while reading them
+	if sw.orderer != nil {
+		held, evicted := sw.orderer.counts()
+		stats.FutureTxs = &FutureTxStats{Held: held, Evicted: evicted}
+	}
+	if sw.orphans != nil {
+		held, evicted := sw.orphans.counts()
+		stats.Orphans = &OrphanStats{Held: held, Evicted: evicted}
+	}
+	return stats
+}
+
+// start the receive routine of the in port, caller must hold the switchMtx.
+func (sw *GossipSwitch) startReceiver(inPort *port.InPort) {
+	quit := make(chan struct{})
+	sw.receivers[inPort.PortId()] = quit
+	sw.routines.Add(1)
+	go sw.receiveRoutine(inPort, quit)
+}
+
+// stop the receive routine of the in port, caller must hold the switchMtx.
+func (sw *GossipSwitch) stopReceiver(portId int) {
+	if quit, ok := sw.receivers[portId]; ok {
+		close(quit)
+		delete(sw.receivers, portId)
+	}
+}
+
+// listen to receive message from the in port until quit is closed
+func (sw *GossipSwitch) receiveRoutine(inPort *port.InPort, quit <-chan struct{}) {
+	defer sw.routines.Done()
 	for {
 		select {
 		case msg := <-inPort.Read():
+			// drop the message if quit is closed at the same time
+			select {
+			case <-quit:
+				return
+			default:
+			}
 			sw.onRecvMsg(inPort.PortId(), msg)
+		case <-quit:
+			return
+		}
+	}
+}
+
+// deal with the received message, return the verification error if the message is rejected.
+func (sw *GossipSwitch) onRecvMsg(portId int, msg interface{}) error {
+	//TODO log.Debug("Received a message %v from port.InPort", msg)
+	counter := sw.counter(portId)
+	counter.received()
+	env := port.NewEnvelope(msg)
+	env.InPort = portId
+	if env.Received.IsZero() {
+		env.Received = time.Now()
+	}
+	if env.Expired() {
+		counter.rejected(ReasonTTLExpired)
+		return ErrTTLExpired
+	}
+	if sw.isDuplicate(env.Msg) {
+		counter.duplicate()
+		return ErrDuplicateMessage
+	}
+	err := sw.verifyAndForward(counter, portId, env)
+	if err != nil && !filter.IsPermanent(err) {
+		// the message may be accepted when it is received again, e.g. once its parent block arrives
+		sw.forgetSeen(env.Msg)
+	}
+	if err == nil && sw.orphans != nil {
+		sw.releaseOrphans(env.Msg)
+	}
+	return err
+}
+
+// verify the message and send it to out ports if it is valid, return the verification error if it is rejected.
+func (sw *GossipSwitch) verifyAndForward(counter *portCounter, portId int, env *port.Envelope) error {
+	start := time.Now()
+	err := filter.VerifyEnvelope(sw.filter, portId, env)
+	counter.verified(err, time.Since(start))
+	if err != nil {
+		if sw.orphans != nil && filter.ErrorReason(err) == filter.ReasonUnknownParent {
+			sw.holdOrphan(portId, env)
 		}
+		return err
+	}
+	if sw.orderer != nil {
+		return sw.orderer.order(portId, env.Forward(), sw.broadCastMsg)
+	}
+	return sw.broadCastMsg(portId, env.Forward())
+}
 
-		//check switch status
-		if !sw.IsRunning() {
-			break
+// hold the block whose parent is unknown, and request its parent
+func (sw *GossipSwitch) holdOrphan(portId int, env *port.Envelope) {
+	if !sw.orphans.add(portId, env) || sw.eventCenter == nil {
+		return
+	}
+	header := env.Msg.(*types.Block).Header
+	log.Info("Request parent block %x of orphan block at height %d", header.PrevBlockHash, header.Height)
+	request := &filter.BlockRequest{
+		Hash:   header.PrevBlockHash,
+		PortId: portId,
+	}
+	if header.Height > 0 {
+		request.Height = header.Height - 1
+	}
+	sw.eventCenter.Notify(filter.EventBlockParentRequested, request)
+}
+
+// verify the orphan blocks descending from the accepted block again, until no more orphan is accepted.
+func (sw *GossipSwitch) releaseOrphans(msg interface{}) {
+	block, ok := msg.(*types.Block)
+	if !ok {
+		return
+	}
+	parents := []types.Hash{filter.HeaderHash(block)}
+	for len(parents) > 0 {
+		parent := parents[0]
+		parents = parents[1:]
+		for _, orphan := range sw.orphans.take(parent) {
+			log.Info("Verify orphan block %x again as its parent %x is accepted", orphan.hash, parent)
+			if err := sw.verifyAndForward(sw.counter(orphan.portId), orphan.portId, orphan.env); err == nil {
+				parents = append(parents, orphan.hash)
+			}
 		}
 	}
 }
 
-// deal with the received message.
-func (sw *GossipSwitch) onRecvMsg(portId int, msg interface{}) {
-	//TODO log.Debug("Received a message %v from port.InPort", msg)
-	if err := sw.filter.Verify(portId, msg); err == nil {
-		sw.broadCastMsg(msg)
+// get the statistics counter of in port. If the port has been removed, a detached counter is returned.
+func (sw *GossipSwitch) counter(portId int) *portCounter {
+	sw.switchMtx.Lock()
+	defer sw.switchMtx.Unlock()
+	if counter, ok := sw.counters[portId]; ok {
+		return counter
 	}
+	return newPortCounter()
 }
 
-// broadcast the validated message to all out ports.
-func (sw *GossipSwitch) broadCastMsg(msg interface{}) error {
+// check whether the message has been received before.
+func (sw *GossipSwitch) isDuplicate(msg interface{}) bool {
+	if sw.seen == nil {
+		return false
+	}
+	hash, ok := msgHash(msg)
+	if !ok {
+		return false
+	}
+	return sw.seen.checkAndAdd(hash)
+}
+
+// forget the message received before, so that it is verified again when it is received next time.
+func (sw *GossipSwitch) forgetSeen(msg interface{}) {
+	if sw.seen == nil {
+		return
+	}
+	if hash, ok := msgHash(msg); ok {
+		sw.seen.forget(hash)
+	}
+}
+
+// broadcast the validated message to the out ports routed from the in port.
+func (sw *GossipSwitch) broadCastMsg(inPortId int, msg interface{}) error {
 	//log.Debug("Broadcast message %v to port.OutPorts", msg)
-	for _, outPort := range sw.outPorts {
-		go outPort.Write(msg)
+	sw.switchMtx.Lock()
+	ctx := sw.runCtx
+	outPorts := make([]*port.OutPort, 0, len(sw.outPorts))
+	for outPortId, outPort := range sw.outPorts {
+		if sw.router.route(inPortId, outPortId) {
+			outPorts = append(outPorts, outPort)
+		}
+	}
+	sw.switchMtx.Unlock()
+
+	for _, outPort := range outPorts {
+		if err := outPort.WriteContext(ctx, msg); err != nil {
+			log.Warn("Failed to write message to out port %d, as: %v", outPort.PortId(), err)
+		}
 	}
 	return nil
 }
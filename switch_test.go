@@ -1,12 +1,16 @@
 package gossipswitch
 
 import (
+	"context"
 	"errors"
 	"github.com/DSiSc/craft/log"
 	"github.com/DSiSc/craft/types"
 	"github.com/DSiSc/gossipswitch/config"
+	"github.com/DSiSc/gossipswitch/filter"
 	"github.com/DSiSc/gossipswitch/port"
 	"github.com/stretchr/testify/assert"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 )
@@ -19,6 +23,14 @@ func (filter *mockSwitchFiler) Verify(portId int, msg interface{}) error {
 	return nil
 }
 
+// mock switch filter which rejects all messages
+type mockRejectFilter struct {
+}
+
+func (rejectFilter *mockRejectFilter) Verify(portId int, msg interface{}) error {
+	return filter.NewVerifyError(filter.ReasonInvalidSignature, errors.New("invalid signature"))
+}
+
 // mock switch config
 func mockSwitchConfig() *config.SwitchConfig {
 	return &config.SwitchConfig{
@@ -42,6 +54,16 @@ func Test_NewGossipSwitchByType(t *testing.T) {
 	assert.Nil(err, "FAILED: failed to create GossipSwitch")
 }
 
+// Test block switch is not created if fork handling is unsupported by the state provider
+func Test_NewGossipSwitchByTypeForkUnsupported(t *testing.T) {
+	assert := assert.New(t)
+	switchConfig := mockSwitchConfig()
+	switchConfig.ForkHandling = true
+	sw, err := NewGossipSwitchByType(BlockSwitch, &eventCenter{}, switchConfig)
+	assert.NotNil(err)
+	assert.Nil(sw)
+}
+
 // Test get switch in port by id
 func Test_InPort(t *testing.T) {
 	assert := assert.New(t)
@@ -67,6 +89,87 @@ func Test_OutPort(t *testing.T) {
 	assert.NotNil(remoteOutPort, "FAILED: failed to get remote out port.")
 }
 
+// Test add in port to switch
+func Test_AddInPort(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+
+	inPort, err := sw.AddInPort(2)
+	assert.Nil(err, "FAILED: failed to add in port")
+	assert.Equal(inPort, sw.InPort(2))
+
+	_, err = sw.AddInPort(port.LocalInPortId)
+	assert.NotNil(err, "FAILED: add in port with duplicated id")
+}
+
+// Test add out port to switch
+func Test_AddOutPort(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+
+	outPort, err := sw.AddOutPort(2)
+	assert.Nil(err, "FAILED: failed to add out port")
+	assert.Equal(outPort, sw.OutPort(2))
+
+	_, err = sw.AddOutPort(port.LocalOutPortId)
+	assert.NotNil(err, "FAILED: add out port with duplicated id")
+}
+
+// Test remove in port from switch
+func Test_RemoveInPort(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+	checkSwitchStatus(t, sw.Start(), sw.isRunning, 1)
+
+	assert.Nil(sw.RemoveInPort(port.RemoteInPortId), "FAILED: failed to remove in port")
+	assert.Nil(sw.InPort(port.RemoteInPortId))
+	_, ok := sw.receivers[port.RemoteInPortId]
+	assert.False(ok, "FAILED: receive routine is not stopped")
+	assert.NotNil(sw.RemoveInPort(port.RemoteInPortId), "FAILED: remove not existed in port")
+}
+
+// Test remove out port from switch
+func Test_RemoveOutPort(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+
+	assert.Nil(sw.RemoveOutPort(port.RemoteOutPortId), "FAILED: failed to remove out port")
+	assert.Nil(sw.OutPort(port.RemoteOutPortId))
+	assert.NotNil(sw.RemoveOutPort(port.RemoteOutPortId), "FAILED: remove not existed out port")
+}
+
+// Test receive message from dynamically added in port
+func Test_AddInPortWhileRunning(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+	checkSwitchStatus(t, sw.Start(), sw.isRunning, 1)
+
+	recvMsgChan := make(chan interface{})
+	outPort, _ := sw.AddOutPort(2)
+	outPort.BindToPort(func(msg interface{}) error {
+		recvMsgChan <- msg
+		return nil
+	})
+
+	inPort, err := sw.AddInPort(2)
+	assert.Nil(err, "FAILED: failed to add in port")
+	txMsg := &types.Transaction{}
+	inPort.Channel() <- txMsg
+
+	ticker := time.NewTicker(2 * time.Second)
+	select {
+	case recvMsg := <-recvMsgChan:
+		assert.Equal(txMsg, recvMsg)
+	case <-ticker.C:
+		assert.Nil(errors.New("failed to receive message"))
+	}
+}
+
 // Test start switch
 func Test_Start(t *testing.T) {
 	assert := assert.New(t)
@@ -90,6 +193,138 @@ func Test_Stop(t *testing.T) {
 	checkSwitchStatus(t, err, sw.isRunning, 0)
 }
 
+// Test stop switch will terminate all receive routines
+func Test_StopTerminateRoutines(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+
+	checkSwitchStatus(t, sw.Start(), sw.isRunning, 1)
+	assert.Nil(sw.Stop())
+	assert.Equal(0, len(sw.receivers))
+
+	// stopped switch should not read message from in port
+	select {
+	case sw.InPort(port.LocalInPortId).Channel() <- &types.Transaction{}:
+		assert.Nil(errors.New("stopped switch still receive message"))
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.NotNil(sw.Stop(), "FAILED: stop a stopped switch")
+}
+
+// Test stop switch will cancel the broadcast blocked by a slow OutPutFunc
+func Test_StopCancelBlockedBroadcast(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	sw.queueSize = 1
+	slowPort, _ := sw.AddOutPort(7)
+	blockChan := make(chan struct{})
+	defer close(blockChan)
+	deliveringChan := make(chan struct{}, 3)
+	slowPort.BindToPort(func(msg interface{}) error {
+		deliveringChan <- struct{}{}
+		<-blockChan
+		return nil
+	})
+	checkSwitchStatus(t, sw.Start(), sw.isRunning, 1)
+
+	// one message is being delivered, one is queued, one is held by dispatch routine and one is in intake queue,
+	// the fifth one blocks the broadcast
+	assert.Nil(sw.Submit(context.Background(), port.LocalInPortId, &types.Transaction{Data: types.TxData{AccountNonce: 1}}))
+	<-deliveringChan
+	for nonce := uint64(2); nonce <= 4; nonce++ {
+		assert.Nil(sw.Submit(context.Background(), port.LocalInPortId, &types.Transaction{Data: types.TxData{AccountNonce: nonce}}))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, sw.Submit(ctx, port.LocalInPortId, &types.Transaction{Data: types.TxData{AccountNonce: 5}}))
+
+	start := time.Now()
+	assert.Nil(sw.Stop())
+	assert.True(time.Since(start) < StopTimeout, "FAILED: blocked broadcast is not canceled")
+}
+
+// Test close switch releases the routines of out ports
+func Test_Close(t *testing.T) {
+	assert := assert.New(t)
+	before := runtime.NumGoroutine()
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	sw.OutPort(port.LocalOutPortId).BindToPort(func(msg interface{}) error { return nil })
+	sw.OutPort(port.RemoteOutPortId).BindToPort(func(msg interface{}) error { return nil })
+	checkSwitchStatus(t, sw.Start(), sw.isRunning, 1)
+
+	assert.Nil(sw.Close())
+	assert.False(sw.IsRunning())
+	assert.NotNil(sw.Close(), "FAILED: close a closed switch")
+	assert.NotNil(sw.Start(), "FAILED: start a closed switch")
+	_, err := sw.AddOutPort(5)
+	assert.NotNil(err, "FAILED: add out port to a closed switch")
+	assert.NotNil(sw.OutPort(port.LocalOutPortId).Write(&types.Transaction{}))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(runtime.NumGoroutine() <= before, "FAILED: routines of closed switch are leaked")
+}
+
+// mock switch filter which blocks until release is closed
+type mockBlockingFilter struct {
+	release chan struct{}
+}
+
+func (blockingFilter *mockBlockingFilter) Verify(portId int, msg interface{}) error {
+	<-blockingFilter.release
+	return nil
+}
+
+// Test restart is refused until the routines of the last run have exited
+func Test_RestartAfterStopTimeout(t *testing.T) {
+	assert := assert.New(t)
+	blockingFilter := &mockBlockingFilter{release: make(chan struct{})}
+	var sw = NewGossipSwitch(blockingFilter)
+	sw.stopTimeout = 100 * time.Millisecond
+	checkSwitchStatus(t, sw.Start(), sw.isRunning, 1)
+
+	sw.InPort(port.LocalInPortId).Channel() <- &types.Transaction{}
+	assert.NotNil(sw.Stop(), "FAILED: stop switch with stuck routine")
+	assert.NotNil(sw.Start(), "FAILED: start switch with stuck routine")
+	assert.False(sw.IsRunning())
+
+	close(blockingFilter.release)
+	<-sw.exited
+	checkSwitchStatus(t, sw.Start(), sw.isRunning, 1)
+	assert.Nil(sw.Stop())
+}
+
+// Test restart a stopped switch
+func Test_Restart(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+
+	checkSwitchStatus(t, sw.Start(), sw.isRunning, 1)
+	assert.NotNil(sw.Start(), "FAILED: start a running switch")
+	assert.Nil(sw.Stop())
+	checkSwitchStatus(t, sw.Start(), sw.isRunning, 1)
+	assert.Equal(len(sw.inPorts), len(sw.receivers))
+
+	recvMsgChan := make(chan interface{}, 1)
+	sw.OutPort(port.LocalOutPortId).BindToPort(func(msg interface{}) error {
+		recvMsgChan <- msg
+		return nil
+	})
+	txMsg := &types.Transaction{}
+	sw.InPort(port.LocalInPortId).Channel() <- txMsg
+	select {
+	case recvMsg := <-recvMsgChan:
+		assert.Equal(txMsg, recvMsg)
+	case <-time.After(2 * time.Second):
+		assert.Nil(errors.New("failed to receive message"))
+	}
+	assert.Nil(sw.Stop())
+}
+
 // Test on receive message
 func Test_onRecvMsg(t *testing.T) {
 	assert := assert.New(t)
@@ -121,6 +356,268 @@ func Test_onRecvMsg(t *testing.T) {
 	}
 }
 
+// Test suppress duplicate message
+func Test_onRecvDuplicateMsg(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+	sw.seen = newSeenCache(16, time.Minute)
+
+	recvMsgChan := make(chan interface{}, 2)
+	sw.OutPort(port.LocalOutPortId).BindToPort(func(msg interface{}) error {
+		recvMsgChan <- msg
+		return nil
+	})
+	txMsg := &types.Transaction{}
+	sw.onRecvMsg(port.RemoteInPortId, txMsg)
+	sw.onRecvMsg(port.LocalInPortId, txMsg)
+
+	assert.Equal(txMsg, <-recvMsgChan)
+	select {
+	case <-recvMsgChan:
+		assert.Nil(errors.New("duplicate message is broadcasted"))
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Equal(uint64(1), sw.DuplicateCount())
+}
+
+// mock switch filter which rejects messages with the queued errors
+type mockErrorFilter struct {
+	errs []error
+}
+
+func (errFilter *mockErrorFilter) Verify(portId int, msg interface{}) error {
+	err := errFilter.errs[0]
+	errFilter.errs = errFilter.errs[1:]
+	return err
+}
+
+// Test message rejected for temporary reason is not taken as duplicate
+func Test_onRecvRejectedMsg(t *testing.T) {
+	assert := assert.New(t)
+	permanentErr := filter.NewVerifyError(filter.ReasonInvalidSignature, errors.New("invalid signature"))
+	var sw = NewGossipSwitch(&mockErrorFilter{errs: []error{
+		filter.NewVerifyError(filter.ReasonUnknownParent, errors.New("unknown parent")),
+		errors.New("failed to get current block"),
+		nil,
+	}})
+	sw.seen = newSeenCache(16, 0)
+
+	txMsg := &types.Transaction{}
+	assert.NotNil(sw.onRecvMsg(port.RemoteInPortId, txMsg))
+	assert.NotNil(sw.onRecvMsg(port.RemoteInPortId, txMsg))
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, txMsg))
+	assert.Equal(ErrDuplicateMessage, sw.onRecvMsg(port.RemoteInPortId, txMsg))
+
+	sw.filter = &mockErrorFilter{errs: []error{permanentErr}}
+	otherMsg := &types.Transaction{Data: types.TxData{AccountNonce: 1}}
+	assert.Equal(permanentErr, sw.onRecvMsg(port.RemoteInPortId, otherMsg))
+	assert.Equal(ErrDuplicateMessage, sw.onRecvMsg(port.RemoteInPortId, otherMsg))
+	assert.Equal(uint64(2), sw.DuplicateCount())
+}
+
+// Test route message by routing table
+func Test_broadCastMsgByRoute(t *testing.T) {
+	assert := assert.New(t)
+	switchConfig := mockSwitchConfig()
+	switchConfig.RoutingPolicy = config.TableRouting
+	switchConfig.RoutingTable = map[int][]int{
+		port.RemoteInPortId: {port.LocalOutPortId},
+	}
+	sw, err := NewGossipSwitchByType(TxSwitch, &eventCenter{}, switchConfig)
+	assert.Nil(err, "FAILED: failed to create GossipSwitch")
+
+	localMsgChan := make(chan interface{}, 1)
+	sw.OutPort(port.LocalOutPortId).BindToPort(func(msg interface{}) error {
+		localMsgChan <- msg
+		return nil
+	})
+	remoteMsgChan := make(chan interface{}, 1)
+	sw.OutPort(port.RemoteOutPortId).BindToPort(func(msg interface{}) error {
+		remoteMsgChan <- msg
+		return nil
+	})
+
+	txMsg := &types.Transaction{}
+	sw.broadCastMsg(port.RemoteInPortId, txMsg)
+	assert.Equal(txMsg, <-localMsgChan)
+	select {
+	case <-remoteMsgChan:
+		assert.Nil(errors.New("message is routed to unexpected out port"))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Test split-horizon routing with the built-in ports
+func Test_broadCastMsgBySplitHorizon(t *testing.T) {
+	assert := assert.New(t)
+	switchConfig := mockSwitchConfig()
+	switchConfig.RoutingPolicy = config.SplitHorizonRouting
+	sw, err := NewGossipSwitchByType(TxSwitch, &eventCenter{}, switchConfig)
+	assert.Nil(err, "FAILED: failed to create GossipSwitch")
+	peerOutPort, _ := sw.AddOutPort(5)
+
+	localMsgChan := make(chan interface{}, 2)
+	sw.OutPort(port.LocalOutPortId).BindToPort(func(msg interface{}) error {
+		localMsgChan <- msg
+		return nil
+	})
+	remoteMsgChan := make(chan interface{}, 2)
+	sw.OutPort(port.RemoteOutPortId).BindToPort(func(msg interface{}) error {
+		remoteMsgChan <- msg
+		return nil
+	})
+	peerMsgChan := make(chan interface{}, 2)
+	peerOutPort.BindToPort(func(msg interface{}) error {
+		peerMsgChan <- msg
+		return nil
+	})
+
+	// local and remote messages reach both local consumer and remote peers
+	localTx := &types.Transaction{}
+	sw.broadCastMsg(port.LocalInPortId, localTx)
+	assert.Equal(localTx, <-localMsgChan)
+	assert.Equal(localTx, <-remoteMsgChan)
+	assert.Equal(localTx, <-peerMsgChan)
+	remoteTx := &types.Transaction{}
+	sw.broadCastMsg(port.RemoteInPortId, remoteTx)
+	assert.Equal(remoteTx, <-localMsgChan)
+	assert.Equal(remoteTx, <-remoteMsgChan)
+	assert.Equal(remoteTx, <-peerMsgChan)
+
+	// message from peer is not sent back to it
+	peerTx := &types.Transaction{}
+	sw.broadCastMsg(5, peerTx)
+	assert.Equal(peerTx, <-localMsgChan)
+	assert.Equal(peerTx, <-remoteMsgChan)
+	select {
+	case <-peerMsgChan:
+		assert.Nil(errors.New("message is sent back to the peer it came from"))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Test submit message to switch
+func Test_Submit(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+
+	txMsg := &types.Transaction{}
+	assert.Equal(ErrSwitchStopped, sw.Submit(context.Background(), port.LocalInPortId, txMsg))
+	checkSwitchStatus(t, sw.Start(), sw.isRunning, 1)
+	assert.NotNil(sw.Submit(context.Background(), 100, txMsg), "FAILED: submit to not existed port")
+
+	recvMsgChan := make(chan interface{}, 1)
+	sw.OutPort(port.LocalOutPortId).BindToPort(func(msg interface{}) error {
+		recvMsgChan <- msg
+		return nil
+	})
+	assert.Nil(sw.Submit(context.Background(), port.LocalInPortId, txMsg))
+	assert.Equal(txMsg, <-recvMsgChan)
+	assert.Nil(sw.Stop())
+}
+
+// Test submit message rejected by filter
+func Test_SubmitRejected(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockRejectFilter{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+	checkSwitchStatus(t, sw.Start(), sw.isRunning, 1)
+
+	err := sw.Submit(context.Background(), port.LocalInPortId, &types.Transaction{})
+	assert.Equal(filter.ReasonInvalidSignature, filter.ErrorReason(err))
+	assert.Nil(sw.Stop())
+}
+
+// Test new a gossipsiwtch by type with custom filters
+func Test_NewGossipSwitchByTypeWithFilters(t *testing.T) {
+	assert := assert.New(t)
+	switchConfig := mockSwitchConfig()
+	switchConfig.PreFilters = []filter.Stage{{Name: "reject", Filter: &mockRejectFilter{}}}
+	sw, err := NewGossipSwitchByType(TxSwitch, &eventCenter{}, switchConfig)
+	assert.Nil(err, "FAILED: failed to create GossipSwitch")
+
+	err = sw.filter.Verify(port.LocalInPortId, &types.Transaction{})
+	assert.Equal(filter.ReasonInvalidSignature, filter.ErrorReason(err))
+	stats := sw.filter.(*filter.Chain).Stats()
+	assert.Equal(2, len(stats))
+	assert.Equal(uint64(1), stats[0].Rejected)
+	assert.Equal(uint64(0), stats[1].Passed+stats[1].Rejected)
+}
+
+// Test receive message wrapped in envelope
+func Test_onRecvEnvelope(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockSwitchFiler{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+
+	recvEnvChan := make(chan *port.Envelope, 1)
+	sw.OutPort(port.LocalOutPortId).BindEnvelopeToPort(func(env *port.Envelope) error {
+		recvEnvChan <- env
+		return nil
+	})
+
+	txMsg := &types.Transaction{}
+	env := port.NewEnvelope(txMsg)
+	env.Origin = "peer"
+	env.TTL = 2
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, env))
+	recvEnv := <-recvEnvChan
+	assert.True(txMsg == recvEnv.Msg)
+	assert.Equal("peer", recvEnv.Origin)
+	assert.Equal(port.RemoteInPortId, recvEnv.InPort)
+	assert.Equal(env.TraceID, recvEnv.TraceID)
+	assert.Equal(uint32(1), recvEnv.Hops)
+	assert.False(recvEnv.Received.IsZero())
+
+	recvEnv.Msg = &types.Transaction{Data: types.TxData{AccountNonce: 1}}
+	assert.Equal(ErrTTLExpired, sw.onRecvMsg(port.RemoteInPortId, recvEnv.Forward()))
+}
+
+// mock switch filter which accepts transactions only
+type mockTxFilter struct {
+}
+
+func (txFilter *mockTxFilter) Verify(portId int, msg interface{}) error {
+	if _, ok := msg.(*types.Transaction); !ok {
+		return errors.New("not a transaction")
+	}
+	return nil
+}
+
+// Test custom SwitchFilter receives the bare message
+func Test_onRecvMsgBareFilter(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockTxFilter{})
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, &types.Transaction{}))
+	assert.Nil(sw.onRecvMsg(port.RemoteInPortId, port.NewEnvelope(&types.Transaction{})))
+
+	switchConfig := mockSwitchConfig()
+	switchConfig.VerifySignature = false
+	switchConfig.PreFilters = []filter.Stage{{Name: "custom", Filter: &mockTxFilter{}}}
+	sw, err := NewGossipSwitchByType(TxSwitch, &eventCenter{}, switchConfig)
+	assert.Nil(err)
+	sw.onRecvMsg(port.RemoteInPortId, &types.Transaction{})
+	assert.Equal(uint64(1), sw.Stats().Filters[0].Passed, "FAILED: custom filter rejects the enveloped message")
+}
+
+// Test get switch statistics
+func Test_Stats(t *testing.T) {
+	assert := assert.New(t)
+	var sw = NewGossipSwitch(&mockRejectFilter{})
+	assert.NotNil(sw, "FAILED: failed to create GossipSwitch")
+
+	sw.onRecvMsg(port.RemoteInPortId, &types.Transaction{})
+	stats := sw.Stats()
+	assert.Equal(CustomSwitch, stats.SwitchType)
+	assert.Equal(2, len(stats.InPorts))
+	assert.Equal(2, len(stats.OutPorts))
+	assert.Equal(uint64(1), stats.InPorts[port.RemoteInPortId].Received)
+	assert.Equal(uint64(1), stats.InPorts[port.RemoteInPortId].Rejected[filter.ReasonInvalidSignature])
+	assert.Equal(uint64(0), stats.InPorts[port.LocalInPortId].Received)
+}
+
 // check switch status
 func checkSwitchStatus(t *testing.T, err error, currentStatus uint32, expectStatus uint32) {
 	assert.Equal(t, expectStatus, currentStatus)
@@ -132,6 +629,8 @@ func checkSwitchStatus(t *testing.T, err error, currentStatus uint32, expectStat
 }
 
 type eventCenter struct {
+	lock   sync.Mutex
+	values map[types.EventType][]interface{}
 }
 
 // subscriber subscribe specified eventType with eventFunc
@@ -145,10 +644,23 @@ func (*eventCenter) UnSubscribe(eventType types.EventType, subscriber types.Subs
 }
 
 // notify subscriber of eventType
-func (*eventCenter) Notify(eventType types.EventType, value interface{}) (err error) {
+func (center *eventCenter) Notify(eventType types.EventType, value interface{}) (err error) {
+	center.lock.Lock()
+	defer center.lock.Unlock()
+	if center.values == nil {
+		center.values = make(map[types.EventType][]interface{})
+	}
+	center.values[eventType] = append(center.values[eventType], value)
 	return nil
 }
 
+// get the values notified with eventType
+func (center *eventCenter) notified(eventType types.EventType) []interface{} {
+	center.lock.Lock()
+	defer center.lock.Unlock()
+	return center.values[eventType]
+}
+
 // notify specified eventFunc
 func (*eventCenter) NotifySubscriber(eventFunc types.EventFunc, value interface{}) {
 